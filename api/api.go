@@ -2,11 +2,15 @@ package api
 
 import (
 	"encoding/json"
-	"io/ioutil"
+	"fmt"
+	"mime"
 	"net/http"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
+	wbclient "github.com/beritani/whitebox/client"
 	"github.com/beritani/whitebox/core"
 )
 
@@ -16,27 +20,135 @@ func upload(w http.ResponseWriter, r *http.Request) {
 	defer client.Unlock()
 
 	r.ParseMultipartForm(32 << 20)
-	reader, _, err := r.FormFile("file")
+	reader, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	defer reader.Close()
 
-	folder, err := client.GetFolderFromPath(client.Root(), r.FormValue("path"))
+	folder, err := client.GetFolderFromPathContext(r.Context(), client.Root(), r.FormValue("path"))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	meta := core.Meta{
-		Name: r.FormValue("name"),
-		Tags: strings.Split(r.FormValue("tags"), ","),
+	meta, err := formMeta(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	data, err := ioutil.ReadAll(reader)
+	// Browsers Send Unknown Types as Octet Streams
+	if contentType := header.Header.Get("Content-Type"); meta.MIME == "" && contentType != "application/octet-stream" {
+		meta.MIME = contentType
+	}
+
+	options, err := uploadOptions(r, client.Options)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	client.Upload(folder, meta, data)
+	err = client.UploadWithOptions(r.Context(), folder, meta, reader, header.Size, options)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// formMeta returns the metadata given in form values. The mode is octal, times
+// are RFC 3339 and attributes are a JSON object of strings.
+func formMeta(r *http.Request) (core.Meta, error) {
+	meta := core.Meta{
+		Name:  r.FormValue("name"),
+		Tags:  strings.Split(r.FormValue("tags"), ","),
+		Owner: r.FormValue("owner"),
+		MIME:  r.FormValue("mime"),
+	}
+
+	if r.FormValue("mode") != "" {
+		mode, err := strconv.ParseUint(r.FormValue("mode"), 8, 32)
+		if err != nil {
+			return meta, err
+		}
+		meta.Mode = uint32(mode)
+	}
+
+	var err error
+	meta.Created, err = formTime(r, "created")
+	if err != nil {
+		return meta, err
+	}
+
+	meta.Modified, err = formTime(r, "modified")
+	if err != nil {
+		return meta, err
+	}
+
+	if r.FormValue("attributes") != "" {
+		err = json.Unmarshal([]byte(r.FormValue("attributes")), &meta.Attributes)
+		if err != nil {
+			return meta, err
+		}
+	}
+
+	return meta, nil
+}
+
+// formTime returns the RFC 3339 time of a form value, or nil if it is empty
+func formTime(r *http.Request, name string) (*time.Time, error) {
+	if r.FormValue(name) == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, r.FormValue(name))
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// uploadOptions overrides the default storage options with form values
+func uploadOptions(r *http.Request, options core.Options) (core.Options, error) {
+	var err error
+	if r.FormValue("compression") != "" {
+		options.Compression, err = core.ParseCompression(r.FormValue("compression"))
+		if err != nil {
+			return options, err
+		}
+	}
+	if r.FormValue("chunking") != "" {
+		options.Chunking, err = core.ParseChunking(r.FormValue("chunking"))
+		if err != nil {
+			return options, err
+		}
+	}
+	if r.FormValue("parity") != "" {
+		options.ParityShards, err = strconv.Atoi(r.FormValue("parity"))
+		if err != nil {
+			return options, err
+		}
+	}
+	if r.FormValue("shards") != "" {
+		options.DataShards, err = strconv.Atoi(r.FormValue("shards"))
+		if err != nil {
+			return options, err
+		}
+	}
+	if r.FormValue("padding") != "" {
+		options.Padding, err = core.ParsePadding(r.FormValue("padding"))
+		if err != nil {
+			return options, err
+		}
+	}
+	if r.FormValue("padding_blocks") != "" {
+		options.PaddingBlocks, err = strconv.Atoi(r.FormValue("padding_blocks"))
+		if err != nil {
+			return options, err
+		}
+	}
+	return options, nil
 }
 
 func info(w http.ResponseWriter, r *http.Request) {
@@ -44,7 +156,7 @@ func info(w http.ResponseWriter, r *http.Request) {
 	client.Lock()
 	defer client.Unlock()
 
-	folder, err := client.GetFolderFromPath(client.Root(), r.FormValue("path"))
+	folder, err := client.GetFolderFromPathContext(r.Context(), client.Root(), r.FormValue("path"))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -65,19 +177,82 @@ func download(w http.ResponseWriter, r *http.Request) {
 	client.Lock()
 	defer client.Unlock()
 
-	folder, err := client.GetFolderFromPath(client.Root(), r.FormValue("path"))
+	folder, err := client.GetFolderFromPathContext(r.Context(), client.Root(), r.FormValue("path"))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	data, err := client.Download(folder.Parent, folder.Index)
+	// The Root Folder has no Key File
+	if folder.KeyFile == nil {
+		http.Error(w, "Cannot download the root folder", http.StatusBadRequest)
+		return
+	}
+
+	meta := *folder.Meta
+	etag := fmt.Sprintf(`"%s"`, core.FileID(folder.PublicKey, folder.KeyFile.FileSalt))
+	var reader *wbclient.FileReader
+	if r.FormValue("version") == "" {
+		reader, err = client.OpenContext(r.Context(), folder.Parent, folder.Index)
+	} else {
+		// Previous Versions are Tagged by Their Content
+		var version uint32
+		version, meta, err = formRevision(r, client, folder)
+		if err == nil {
+			etag = ""
+			if meta.SHA256 != "" {
+				etag = fmt.Sprintf(`"%s"`, meta.SHA256)
+			}
+			reader, err = client.OpenRevisionContext(r.Context(), folder, version)
+		}
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	defer reader.Close()
 
-	w.Write(data)
+	// Content Headers
+	contentType := meta.MIME
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(meta.Name))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+
+	// Serve Range, If-Range and Multipart Range Requests
+	modified := time.Time{}
+	if meta.Modified != nil {
+		modified = *meta.Modified
+	}
+	http.ServeContent(w, r, meta.Name, modified, reader)
+}
+
+// formRevision returns the version of a file given in the version form value
+// and its metadata
+func formRevision(r *http.Request, client *Client, folder *wbclient.Folder) (uint32, core.Meta, error) {
+	version, err := strconv.ParseUint(r.FormValue("version"), 10, 32)
+	if err != nil {
+		return 0, core.Meta{}, fmt.Errorf("Invalid version %q", r.FormValue("version"))
+	}
+
+	revisions, err := client.LsRevisionsContext(r.Context(), folder)
+	if err != nil {
+		return 0, core.Meta{}, err
+	}
+
+	for _, revision := range revisions {
+		if revision.Version == uint32(version) {
+			return revision.Version, revision.Meta, nil
+		}
+	}
+
+	return 0, core.Meta{}, fmt.Errorf("%w: revision %d", wbclient.ErrNotExist, version)
 }
 
 func mkdir(w http.ResponseWriter, r *http.Request) {
@@ -87,18 +262,23 @@ func mkdir(w http.ResponseWriter, r *http.Request) {
 
 	path := filepath.Clean(r.FormValue("path"))
 
-	folder, err := client.GetFolderFromPath(client.Root(), path)
+	folder, err := client.GetFolderFromPathContext(r.Context(), client.Root(), path)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	meta := core.Meta{
-		Name: r.FormValue("name"),
-		Tags: strings.Split(r.FormValue("tags"), ","),
+	meta, err := formMeta(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	client.Mkdir(folder, meta)
+	_, err = client.MkdirContext(r.Context(), folder, meta)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 }
 
 func pwd(w http.ResponseWriter, r *http.Request) {
@@ -114,7 +294,7 @@ func cd(w http.ResponseWriter, r *http.Request) {
 	client.Lock()
 	defer client.Unlock()
 
-	_, err := client.Cd(r.FormValue("path"))
+	_, err := client.CdContext(r.Context(), r.FormValue("path"))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -127,7 +307,7 @@ func ls(w http.ResponseWriter, r *http.Request) {
 	client.Lock()
 	defer client.Unlock()
 
-	folder, err := client.GetFolderFromPath(client.Root(), r.FormValue("path"))
+	folder, err := client.GetFolderFromPathContext(r.Context(), client.Root(), r.FormValue("path"))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -138,7 +318,11 @@ func ls(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	files := client.Ls(folder)
+	files, err := client.LsContext(r.Context(), folder)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	children := map[uint32]core.Meta{}
 	for index, file := range files {
@@ -160,13 +344,31 @@ func rm(w http.ResponseWriter, r *http.Request) {
 	client.Lock()
 	defer client.Unlock()
 
-	folder, err := client.GetFolderFromPath(client.Root(), r.FormValue("path"))
+	folder, err := client.GetFolderFromPathContext(r.Context(), client.Root(), r.FormValue("path"))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	err = client.Rm(folder)
+	if r.FormValue("dry_run") == "true" {
+		plan, err := client.RmDryRunContext(r.Context(), folder)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		data, err := json.Marshal(plan)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+		return
+	}
+
+	err = client.RmContext(r.Context(), folder)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -174,18 +376,346 @@ func rm(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("done"))
 }
 
+func rename(w http.ResponseWriter, r *http.Request) {
+	client := getClient(r)
+	client.Lock()
+	defer client.Unlock()
+
+	folder, err := client.GetFolderFromPathContext(r.Context(), client.Root(), r.FormValue("path"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.FormValue("name") == "" {
+		http.Error(w, "Missing name", http.StatusBadRequest)
+		return
+	}
+
+	err = client.RenameContext(r.Context(), folder, r.FormValue("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func mv(w http.ResponseWriter, r *http.Request) {
+	client := getClient(r)
+	client.Lock()
+	defer client.Unlock()
+
+	folder, err := client.GetFolderFromPathContext(r.Context(), client.Root(), r.FormValue("path"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dest, err := client.GetFolderFromPathContext(r.Context(), client.Root(), r.FormValue("dest"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	moved, err := client.MoveContext(r.Context(), folder, dest)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := json.Marshal(map[string]string{"Path": moved.Path})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func cp(w http.ResponseWriter, r *http.Request) {
+	client := getClient(r)
+	client.Lock()
+	defer client.Unlock()
+
+	folder, err := client.GetFolderFromPathContext(r.Context(), client.Root(), r.FormValue("path"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dest, err := client.GetFolderFromPathContext(r.Context(), client.Root(), r.FormValue("dest"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var progress wbclient.CopyProgress
+	copied, err := client.CopyContext(r.Context(), folder, dest, func(p wbclient.CopyProgress) {
+		progress = p
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := json.Marshal(struct {
+		Path    string
+		Entries int
+		Bytes   int64
+	}{copied.Path, progress.Entries, progress.Bytes})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func update(w http.ResponseWriter, r *http.Request) {
+	client := getClient(r)
+	client.Lock()
+	defer client.Unlock()
+
+	r.ParseMultipartForm(32 << 20)
+	reader, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer reader.Close()
+
+	folder, err := client.GetFolderFromPathContext(r.Context(), client.Root(), r.FormValue("path"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = client.UpdateFromContext(r.Context(), folder, reader, header.Size)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func revisions(w http.ResponseWriter, r *http.Request) {
+	client := getClient(r)
+	client.Lock()
+	defer client.Unlock()
+
+	folder, err := client.GetFolderFromPathContext(r.Context(), client.Root(), r.FormValue("path"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	list, err := client.LsRevisionsContext(r.Context(), folder)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func rollback(w http.ResponseWriter, r *http.Request) {
+	client := getClient(r)
+	client.Lock()
+	defer client.Unlock()
+
+	folder, err := client.GetFolderFromPathContext(r.Context(), client.Root(), r.FormValue("path"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	version, err := strconv.ParseUint(r.FormValue("version"), 10, 32)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid version %q", r.FormValue("version")), http.StatusBadRequest)
+		return
+	}
+
+	file, err := client.RollbackContext(r.Context(), folder, uint32(version))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	current, err := file.KeyFile.GetVersion()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data, err := json.Marshal(map[string]uint32{"Version": current})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func trash(w http.ResponseWriter, r *http.Request) {
+	client := getClient(r)
+	client.Lock()
+	defer client.Unlock()
+
+	folder, err := client.GetFolderFromPathContext(r.Context(), client.Root(), r.FormValue("path"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := client.LsTrashContext(r.Context(), folder)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type trashed struct {
+		Path    string
+		Meta    core.Meta
+		Trashed time.Time
+		Expires time.Time
+	}
+
+	list := []trashed{}
+	for _, entry := range entries {
+		list = append(list, trashed{entry.Path, *entry.Folder.Meta, entry.Trashed, entry.Expires})
+	}
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func restore(w http.ResponseWriter, r *http.Request) {
+	client := getClient(r)
+	client.Lock()
+	defer client.Unlock()
+
+	folder, err := client.GetTrashedContext(r.Context(), client.Root(), r.FormValue("path"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	restored, err := client.RestoreContext(r.Context(), folder)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if restored == nil {
+		http.Error(w, wbclient.ErrNotExist.Error(), http.StatusNotFound)
+		return
+	}
+
+	data, err := json.Marshal(map[string]string{"Path": restored.Path})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func purge(w http.ResponseWriter, r *http.Request) {
+	client := getClient(r)
+	client.Lock()
+	defer client.Unlock()
+
+	// Purge Expired Files Without a Path
+	if r.FormValue("path") == "" {
+		purged, err := client.PurgeExpiredContext(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		data, err := json.Marshal(map[string]int{"Purged": purged})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+		return
+	}
+
+	folder, err := client.GetTrashedContext(r.Context(), client.Root(), r.FormValue("path"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = client.PurgeContext(r.Context(), folder)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Write([]byte("done"))
+}
+
+func repair(w http.ResponseWriter, r *http.Request) {
+	client := getClient(r)
+	client.Lock()
+	defer client.Unlock()
+
+	folder, err := client.GetFolderFromPathContext(r.Context(), client.Root(), r.FormValue("path"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	repaired, err := client.RepairContext(r.Context(), folder)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := json.Marshal(map[string]int{"Repaired": repaired})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
 func refresh(w http.ResponseWriter, r *http.Request) {
 	client := getClient(r)
 	client.Lock()
 	defer client.Unlock()
 
-	folder, err := client.GetFolderFromPath(client.Root(), r.FormValue("path"))
+	folder, err := client.GetFolderFromPathContext(r.Context(), client.Root(), r.FormValue("path"))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	client.Refresh(folder)
+	err = client.RefreshContext(r.Context(), folder)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 }
 
 func publickey(w http.ResponseWriter, r *http.Request) {
@@ -195,7 +725,7 @@ func publickey(w http.ResponseWriter, r *http.Request) {
 
 	path := filepath.Clean(r.FormValue("path"))
 
-	folder, err := client.GetFolderFromPath(client.Root(), path)
+	folder, err := client.GetFolderFromPathContext(r.Context(), client.Root(), path)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -214,13 +744,13 @@ func query(w http.ResponseWriter, r *http.Request) {
 	path := filepath.Clean(r.FormValue("path"))
 	query := r.FormValue("query")
 
-	folder, err := client.GetFolderFromPath(client.Root(), path)
+	folder, err := client.GetFolderFromPathContext(r.Context(), client.Root(), path)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	results, err := client.Find(folder, query, nil)
+	results, err := client.FindContext(r.Context(), folder, query, nil)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -30,19 +30,25 @@ func login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	handlers := GetLocalHandlers(envData)
-	client, err := client.NewClient(mnemonic, password, int(envSize), handlers)
+	client, err := client.NewContextClient(mnemonic, password, int(envSize), envHandlers)
 	if err != nil {
 		fmt.Println(err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	client.Workers = envWorkers
+	client.Verify = envVerify
+	client.Options = envOptions
+	client.Retention = envRetention
+	client.Revisions = envRevisions
 
 	clientID := client.ID()
+	clientsMutex.Lock()
 	clients[clientID] = &Client{
 		Client: client,
 		mutex:  &sync.Mutex{},
 	}
+	clientsMutex.Unlock()
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(Session{ID: client.ID()})
@@ -50,7 +56,9 @@ func login(w http.ResponseWriter, r *http.Request) {
 
 func logout(w http.ResponseWriter, r *http.Request) {
 	sessionID := r.Header.Get("X-Session-Id")
+	clientsMutex.Lock()
 	delete(clients, sessionID)
+	clientsMutex.Unlock()
 }
 
 func register(w http.ResponseWriter, r *http.Request) {
@@ -71,11 +79,10 @@ func wordlist(w http.ResponseWriter, r *http.Request) {
 
 func verify(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		sessionID := r.Header.Get("X-Session-Id")
-		if _, ok := clients[sessionID]; ok {
+		if getClient(r) != nil {
 			next.ServeHTTP(w, r)
 		} else {
 			http.Error(w, "Unauthorised access", http.StatusUnauthorized)
 		}
 	})
-}
\ No newline at end of file
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/beritani/whitebox/client"
+	"github.com/gorilla/mux"
+)
+
+// BlobStore serves opaque blobs from a storage backend without any
+// knowledge of the keys used to encrypt them
+type BlobStore struct {
+	handlers client.ContextHandlers
+	token    string
+	maxSize  int64
+}
+
+// NewBlobStore returns a blob store for handlers, requiring token as a
+// bearer token if it is not empty
+func NewBlobStore(handlers client.ContextHandlers, token string, maxSize int64) *BlobStore {
+	return &BlobStore{
+		handlers: handlers,
+		token:    token,
+		maxSize:  maxSize,
+	}
+}
+
+// Router returns the blob store routes
+func (s *BlobStore) Router() *mux.Router {
+	router := mux.NewRouter()
+	blobs := router.PathPrefix("/blobs").Subrouter()
+	blobs.Use(s.authorise)
+	blobs.HandleFunc("/{id:[0-9a-f]{64}}", s.put).Methods("PUT")
+	blobs.HandleFunc("/{id:[0-9a-f]{64}}", s.get).Methods("GET")
+	blobs.HandleFunc("/{id:[0-9a-f]{64}}", s.delete).Methods("DELETE")
+	blobs.HandleFunc("/{id:[0-9a-f]{64}}", s.head).Methods("HEAD")
+	return router
+}
+
+func (s *BlobStore) authorise(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expected := []byte("Bearer " + s.token)
+		actual := []byte(r.Header.Get("Authorization"))
+		if s.token != "" && subtle.ConstantTimeCompare(expected, actual) != 1 {
+			http.Error(w, "Unauthorised access", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *BlobStore) put(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, s.maxSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	err = s.handlers.Upload(r.Context(), id, data)
+	if errors.Is(err, ErrRejected) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *BlobStore) get(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	exists, err := s.handlers.Exists(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !exists {
+		http.Error(w, "Blob does not exist", http.StatusNotFound)
+		return
+	}
+
+	data, err := s.handlers.Download(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
+
+func (s *BlobStore) delete(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	exists, err := s.handlers.Exists(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !exists {
+		http.Error(w, "Blob does not exist", http.StatusNotFound)
+		return
+	}
+
+	err = s.handlers.Delete(r.Context(), id)
+	if errors.Is(err, ErrRejected) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *BlobStore) head(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	exists, err := s.handlers.Exists(r.Context(), id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// StartBlobStore starts a standalone blob store server
+func StartBlobStore() {
+	envData = getEnv("DATA_PATH", "/data")
+	host := fmt.Sprintf("%s:%s", getEnv("BLOB_HOST", "0.0.0.0"), getEnv("BLOB_PORT", "8081"))
+	maxSize, err := strconv.ParseInt(getEnv("BLOB_MAX_SIZE", strconv.Itoa(64<<20)), 10, 0)
+	if err != nil || maxSize <= 0 {
+		log.Fatal("BLOB_MAX_SIZE must be a number greater than 0")
+	}
+
+	handlers, err := getHandlers()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	store := NewBlobStore(handlers, getEnv("BLOB_TOKEN", ""), maxSize)
+
+	log.Printf(`Starting blob store on http://%s`, host)
+	log.Fatal(http.ListenAndServe(host, store.Router()))
+}
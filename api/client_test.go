@@ -0,0 +1,346 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	wbclient "github.com/beritani/whitebox/client"
+	"github.com/beritani/whitebox/core"
+)
+
+// testMnemonic is the account the legacy test data was written with
+const testMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+var errUploadFailed = errors.New("Upload failed")
+
+// failingHandlers are local handlers whose failAt-th upload fails
+type failingHandlers struct {
+	LocalHandlers
+	uploads int
+	failAt  int
+}
+
+// Upload ...
+func (h *failingHandlers) Upload(id string, data []byte) error {
+	h.uploads++
+	if h.uploads == h.failAt {
+		return errUploadFailed
+	}
+	return h.LocalHandlers.Upload(id, data)
+}
+
+// newLocalClient returns a client over local handlers in a temporary
+// directory with the files of fixture, if it is set, from testdata
+func newLocalClient(t *testing.T, fixture string) (*wbclient.Client, *failingHandlers) {
+	dir := t.TempDir()
+	if fixture != "" {
+		files, err := ioutil.ReadDir(filepath.Join("testdata", fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range files {
+			data, err := ioutil.ReadFile(filepath.Join("testdata", fixture, file.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ioutil.WriteFile(filepath.Join(dir, file.Name()), data, 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	handlers := &failingHandlers{LocalHandlers: GetLocalHandlers(dir)}
+	client, err := wbclient.NewClient(testMnemonic, "", 64, handlers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Workers = 1
+	return client, handlers
+}
+
+// storedBlocks returns the number of stored blobs that are not key files,
+// as removed files keep tombstone key files in their slots
+func storedBlocks(t *testing.T, handlers *failingHandlers) int {
+	files, err := ioutil.ReadDir(handlers.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	for _, file := range files {
+		data, err := ioutil.ReadFile(filepath.Join(handlers.path, file.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := core.UnmarshalKeyFile(data); err != nil {
+			count++
+		}
+	}
+	return count
+}
+
+func downloadPath(t *testing.T, client *wbclient.Client, path string) []byte {
+	file, err := client.GetFolderFromPath(client.Root(), path)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+
+	data, err := client.Download(file.Parent, file.Index)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return data
+}
+
+func randomData(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	return data
+}
+
+func TestClientLegacyRead(t *testing.T) {
+	client, _ := newLocalClient(t, "legacy")
+
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if got := downloadPath(t, client, "/legacy.bin"); !bytes.Equal(got, data) {
+		t.Fatal("Legacy file data differs")
+	}
+
+	if got := downloadPath(t, client, "/dir/inner.txt"); string(got) != "inner legacy" {
+		t.Fatalf("Legacy file in folder = %q, expected %q", got, "inner legacy")
+	}
+
+	// New Files in Legacy Folders
+	folder, err := client.GetFolderFromPath(client.Root(), "/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.Upload(folder, core.Meta{Name: "new.txt", Type: "file"}, []byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := downloadPath(t, client, "/dir/new.txt"); string(got) != "new" {
+		t.Fatalf("New file in legacy folder = %q, expected %q", got, "new")
+	}
+}
+
+func TestClientParityRecovery(t *testing.T) {
+	client, handlers := newLocalClient(t, "")
+	data := randomData(64*9 + 10)
+
+	options := core.Options{DataShards: 4, ParityShards: 2}
+	err := client.UploadWithOptions(context.Background(), client.Root(), core.Meta{Name: "parity", Type: "file"}, bytes.NewReader(data), int64(len(data)), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := client.GetFolderFromPath(client.Root(), "/parity")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Delete Two Blocks of the First Group
+	fileID := core.FileID(file.PublicKey, file.KeyFile.FileSalt)
+	for _, i := range []int{0, 3} {
+		err = handlers.Delete(core.BlockID(fileID, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := downloadPath(t, client, "/parity"); !bytes.Equal(got, data) {
+		t.Fatal("Recovered file data differs")
+	}
+
+	repaired, err := client.Repair(file)
+	if err != nil || repaired != 2 {
+		t.Fatalf("Repair = %d, %v, expected 2 blocks", repaired, err)
+	}
+	if !handlers.Exists(core.BlockID(fileID, 0)) || !handlers.Exists(core.BlockID(fileID, 3)) {
+		t.Fatal("Repair did not store the deleted blocks")
+	}
+
+	// Too Many Blocks of a Group Lost
+	for _, i := range []int{0, 1, 2} {
+		err = handlers.Delete(core.BlockID(fileID, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := client.Download(file.Parent, file.Index); err == nil {
+		t.Fatal("Download with three blocks of a group lost succeeded")
+	}
+}
+
+func TestClientTrash(t *testing.T) {
+	client, handlers := newLocalClient(t, "")
+	client.Retention = time.Hour
+	data := randomData(1000)
+
+	err := client.Upload(client.Root(), core.Meta{Name: "file", Type: "file"}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := storedBlocks(t, handlers)
+
+	// Trash Keeps the Blocks
+	file, err := client.GetFolderFromPath(client.Root(), "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.Rm(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetFolderFromPath(client.Root(), "/file"); err == nil {
+		t.Fatal("Trashed file still resolves")
+	}
+	if storedBlocks(t, handlers) != blocks {
+		t.Fatal("Trash deleted blocks")
+	}
+
+	entries, err := client.LsTrash(client.Root())
+	if err != nil || len(entries) != 1 || entries[0].Path != "/#1" {
+		t.Fatalf("LsTrash = %v, %v", entries, err)
+	}
+
+	// Restore
+	trashed, err := client.GetTrashed(client.Root(), "/#1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Restore(trashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := downloadPath(t, client, "/file"); !bytes.Equal(got, data) {
+		t.Fatal("Restored file data differs")
+	}
+
+	// Purge Only Once Expired
+	file, err = client.GetFolderFromPath(client.Root(), "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.Rm(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged, err := client.PurgeExpired(); purged != 0 || err != nil {
+		t.Fatalf("PurgeExpired before expiry = %d, %v", purged, err)
+	}
+
+	client.Retention = 0
+	if purged, err := client.PurgeExpired(); purged != 1 || err != nil {
+		t.Fatalf("PurgeExpired = %d, %v, expected 1", purged, err)
+	}
+	if entries, err := client.LsTrash(client.Root()); err != nil || len(entries) != 0 {
+		t.Fatalf("LsTrash after purge = %v, %v", entries, err)
+	}
+	if blocks := storedBlocks(t, handlers); blocks != 0 {
+		t.Fatalf("Purge left %d blocks", blocks)
+	}
+}
+
+// testRollback fails each upload of change in turn until it succeeds,
+// checking after every failure that the files are unchanged, and then that
+// the files are at paths. change returns true if it took effect even though
+// it failed, as a move does once its copy is complete.
+func testRollback(t *testing.T, name string, paths []string, change func(*wbclient.Client) (bool, error)) {
+	client, handlers := newLocalClient(t, "")
+	client.Size = 1024
+	data := randomData(3000)
+
+	folder, err := client.Mkdir(client.Root(), core.Meta{Name: "folder", Type: "folder"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.Upload(folder, core.Meta{Name: "file", Type: "file"}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.UploadWithOptions(context.Background(), folder, core.Meta{Name: "chunked", Type: "file"}, bytes.NewReader(data), int64(len(data)), core.Options{Chunking: core.ChunkingContent})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Mkdir(client.Root(), core.Meta{Name: "other", Type: "folder"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := storedBlocks(t, handlers)
+
+	for failAt := 1; ; failAt++ {
+		handlers.uploads, handlers.failAt = 0, failAt
+
+		// A Fresh Client, as Failed Changes may Leave the Cache Stale
+		client, err = wbclient.NewClient(testMnemonic, "", 1024, handlers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.Workers = 1
+
+		changed, err := change(client)
+		if err != nil && !errors.Is(err, errUploadFailed) {
+			t.Fatalf("%s failing upload %d: %v", name, failAt, err)
+		}
+
+		if err != nil && !changed && storedBlocks(t, handlers) != blocks {
+			t.Fatalf("%s failing upload %d left %d blocks", name, failAt, storedBlocks(t, handlers)-blocks)
+		}
+
+		done := err == nil || changed
+		check := []string{"/folder/file", "/folder/chunked"}
+		if done {
+			check = paths
+		}
+
+		handlers.failAt = 0
+		client, err = wbclient.NewClient(testMnemonic, "", 1024, handlers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range check {
+			if got := downloadPath(t, client, path); !bytes.Equal(got, data) {
+				t.Fatalf("%s failing upload %d lost %s", name, failAt, path)
+			}
+		}
+
+		if done {
+			return
+		}
+	}
+}
+
+func TestClientRollback(t *testing.T) {
+	resolve := func(client *wbclient.Client, path string) *wbclient.Folder {
+		folder, err := client.GetFolderFromPath(client.Root(), path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return folder
+	}
+
+	testRollback(t, "Rename", []string{"/folder/renamed", "/folder/chunked"}, func(client *wbclient.Client) (bool, error) {
+		return false, client.Rename(resolve(client, "/folder/file"), "renamed")
+	})
+
+	testRollback(t, "Move", []string{"/other/folder/file", "/other/folder/chunked"}, func(client *wbclient.Client) (bool, error) {
+		moved, err := client.Move(resolve(client, "/folder"), resolve(client, "/other"))
+		return moved != nil, err
+	})
+
+	testRollback(t, "Copy", []string{"/folder/file", "/other/folder/file", "/other/folder/chunked"}, func(client *wbclient.Client) (bool, error) {
+		_, err := client.Copy(resolve(client, "/folder"), resolve(client, "/other"))
+		return false, err
+	})
+}
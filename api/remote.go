@@ -0,0 +1,107 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// RemoteHandlers stores files on a remote blob store
+type RemoteHandlers struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// GetRemoteHandlers ...
+func GetRemoteHandlers(url string, token string) RemoteHandlers {
+	return RemoteHandlers{
+		url:    strings.TrimSuffix(url, "/"),
+		token:  token,
+		client: &http.Client{},
+	}
+}
+
+// Upload ...
+func (h RemoteHandlers) Upload(ctx context.Context, id string, data []byte) error {
+	res, err := h.do(ctx, http.MethodPut, id, data)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	return remoteError(res, http.StatusNoContent)
+}
+
+// Download ...
+func (h RemoteHandlers) Download(ctx context.Context, id string) ([]byte, error) {
+	res, err := h.do(ctx, http.MethodGet, id, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	err = remoteError(res, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(res.Body)
+}
+
+// Delete ...
+func (h RemoteHandlers) Delete(ctx context.Context, id string) error {
+	res, err := h.do(ctx, http.MethodDelete, id, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	return remoteError(res, http.StatusNoContent)
+}
+
+// Exists ...
+func (h RemoteHandlers) Exists(ctx context.Context, id string) (bool, error) {
+	res, err := h.do(ctx, http.MethodHead, id, nil)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+
+	err = remoteError(res, http.StatusOK)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (h RemoteHandlers) do(ctx context.Context, method string, id string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s/blobs/%s", h.url, id), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+
+	return h.client.Do(req)
+}
+
+func remoteError(res *http.Response, expected int) error {
+	if res.StatusCode == expected {
+		return nil
+	}
+
+	message, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	return fmt.Errorf("Blob store %s %s: %s %s", res.Request.Method, res.Request.URL.Path, res.Status, bytes.TrimSpace(message))
+}
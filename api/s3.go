@@ -0,0 +1,364 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MinS3PartSize is the smallest part size accepted by S3 multipart uploads
+const MinS3PartSize = 5 << 20
+
+// S3Config configures an S3 compatible bucket
+type S3Config struct {
+	Endpoint     string
+	Region       string
+	Bucket       string
+	Prefix       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	PathStyle    bool
+	PartSize     int
+}
+
+// S3Handlers stores files as objects in an S3 compatible bucket
+type S3Handlers struct {
+	config S3Config
+	client *http.Client
+}
+
+// GetS3Handlers ...
+func GetS3Handlers(config S3Config) S3Handlers {
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.PartSize < MinS3PartSize {
+		config.PartSize = MinS3PartSize
+	}
+
+	return S3Handlers{
+		config: config,
+		client: &http.Client{},
+	}
+}
+
+// Upload ...
+func (h S3Handlers) Upload(ctx context.Context, id string, data []byte) error {
+	if len(data) > h.config.PartSize {
+		return h.uploadMultipart(ctx, id, data)
+	}
+
+	res, err := h.do(ctx, http.MethodPut, id, nil, data)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	return s3Error(res, http.StatusOK)
+}
+
+// Download ...
+func (h S3Handlers) Download(ctx context.Context, id string) ([]byte, error) {
+	res, err := h.do(ctx, http.MethodGet, id, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	err = s3Error(res, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(res.Body)
+}
+
+// Delete ...
+func (h S3Handlers) Delete(ctx context.Context, id string) error {
+	res, err := h.do(ctx, http.MethodDelete, id, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	return s3Error(res, http.StatusOK, http.StatusNoContent)
+}
+
+// Exists ...
+func (h S3Handlers) Exists(ctx context.Context, id string) (bool, error) {
+	res, err := h.do(ctx, http.MethodHead, id, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+
+	err = s3Error(res, http.StatusOK)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+type s3InitiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type s3CompleteMultipartUpload struct {
+	XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletedPart `xml:"Part"`
+}
+
+// s3CompleteMultipartUploadResult is the body of a completed upload, or of
+// an error if completing it failed
+type s3CompleteMultipartUploadResult struct {
+	XMLName xml.Name
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (h S3Handlers) uploadMultipart(ctx context.Context, id string, data []byte) error {
+	// Create Upload
+	res, err := h.do(ctx, http.MethodPost, id, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	err = s3Error(res, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	var upload s3InitiateMultipartUploadResult
+	err = xml.NewDecoder(res.Body).Decode(&upload)
+	if err != nil {
+		return err
+	}
+
+	// Upload Parts
+	complete := s3CompleteMultipartUpload{}
+	for offset := 0; offset < len(data); offset += h.config.PartSize {
+		end := offset + h.config.PartSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		part := s3CompletedPart{PartNumber: len(complete.Parts) + 1}
+		part.ETag, err = h.uploadPart(ctx, id, upload.UploadID, part.PartNumber, data[offset:end])
+		if err != nil {
+			h.abortMultipart(id, upload.UploadID)
+			return err
+		}
+		complete.Parts = append(complete.Parts, part)
+	}
+
+	// Complete Upload
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		h.abortMultipart(id, upload.UploadID)
+		return err
+	}
+
+	err = h.completeMultipart(ctx, id, upload.UploadID, body)
+	if err != nil {
+		h.abortMultipart(id, upload.UploadID)
+		return err
+	}
+
+	return nil
+}
+
+// completeMultipart completes a multipart upload. S3 can fail to complete an
+// upload after it has sent a 200 status, in which case the body is an error.
+func (h S3Handlers) completeMultipart(ctx context.Context, id string, uploadID string, body []byte) error {
+	res, err := h.do(ctx, http.MethodPost, id, url.Values{"uploadId": {uploadID}}, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	err = s3Error(res, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	var result s3CompleteMultipartUploadResult
+	err = xml.NewDecoder(res.Body).Decode(&result)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if result.XMLName.Local == "Error" {
+		return fmt.Errorf("S3 %s %s: %s %s", res.Request.Method, res.Request.URL.Path, result.Code, result.Message)
+	}
+
+	return nil
+}
+
+func (h S3Handlers) uploadPart(ctx context.Context, id string, uploadID string, number int, data []byte) (string, error) {
+	query := url.Values{
+		"partNumber": {strconv.Itoa(number)},
+		"uploadId":   {uploadID},
+	}
+
+	res, err := h.do(ctx, http.MethodPut, id, query, data)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	err = s3Error(res, http.StatusOK)
+	if err != nil {
+		return "", err
+	}
+
+	return res.Header.Get("ETag"), nil
+}
+
+func (h S3Handlers) abortMultipart(id string, uploadID string) {
+	// Abort even if the upload context was cancelled
+	res, err := h.do(context.Background(), http.MethodDelete, id, url.Values{"uploadId": {uploadID}}, nil)
+	if err == nil {
+		res.Body.Close()
+	}
+}
+
+func (h S3Handlers) objectURL(id string) (*url.URL, error) {
+	endpoint, err := url.Parse(h.config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	key := path.Join("/", h.config.Prefix, id)
+	if h.config.PathStyle {
+		endpoint.Path = path.Join("/", h.config.Bucket) + key
+	} else {
+		endpoint.Host = h.config.Bucket + "." + endpoint.Host
+		endpoint.Path = key
+	}
+
+	return endpoint, nil
+}
+
+func (h S3Handlers) do(ctx context.Context, method string, id string, query url.Values, body []byte) (*http.Response, error) {
+	objectURL, err := h.objectURL(id)
+	if err != nil {
+		return nil, err
+	}
+	objectURL.RawQuery = s3Query(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, objectURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	if h.config.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", h.config.SessionToken)
+	}
+	signS3Request(req, body, h.config.Region, h.config.AccessKey, h.config.SecretKey, time.Now())
+
+	return h.client.Do(req)
+}
+
+func s3Error(res *http.Response, expected ...int) error {
+	for _, status := range expected {
+		if res.StatusCode == status {
+			return nil
+		}
+	}
+
+	message, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	return fmt.Errorf("S3 %s %s: %s %s", res.Request.Method, res.Request.URL.Path, res.Status, bytes.TrimSpace(message))
+}
+
+// s3Query encodes a query string as required by AWS signature version 4
+func s3Query(query url.Values) string {
+	return strings.Replace(query.Encode(), "+", "%20", -1)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// signS3Request signs req with AWS signature version 4, covering the host,
+// the payload hash and every header already set on req
+func signS3Request(req *http.Request, body []byte, region string, accessKey string, secretKey string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256.Sum256(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	// Canonical Headers
+	headers := map[string]string{"host": req.URL.Host}
+	for key, values := range req.Header {
+		headers[strings.ToLower(key)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	// Canonical Request
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3Query(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+
+	// Signature
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature))
+}
@@ -0,0 +1,368 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	testAccessKey = "AKIDEXAMPLE"
+	testSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	testRegion    = "eu-west-2"
+	testBucket    = "whitebox"
+)
+
+// fakeS3 is a MinIO-style stand-in for an S3 bucket which checks the
+// signature of every request
+type fakeS3 struct {
+	mutex    sync.Mutex
+	objects  map[string][]byte
+	uploads  map[string]map[int][]byte
+	requests []string
+	failPart int
+	// failComplete is the status completing uploads fails with, and a 200
+	// status fails with an error body
+	failComplete int
+	aborted      int
+	next         int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		objects: map[string][]byte{},
+		uploads: map[string]map[int][]byte{},
+	}
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = checkSignature(r, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	key := r.URL.Path
+	query := r.URL.Query()
+	s.requests = append(s.requests, r.Method+" "+r.URL.RawQuery)
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		s.next++
+		uploadID := "upload-" + strconv.Itoa(s.next)
+		s.uploads[uploadID] = map[int][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", uploadID)
+
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		parts, ok := s.uploads[query.Get("uploadId")]
+		if !ok {
+			http.Error(w, "NoSuchUpload", http.StatusNotFound)
+			return
+		}
+
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		if number == s.failPart {
+			http.Error(w, "InternalError", http.StatusInternalServerError)
+			return
+		}
+		parts[number] = body
+		w.Header().Set("ETag", partETag(body))
+
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		parts, ok := s.uploads[query.Get("uploadId")]
+		if !ok {
+			http.Error(w, "NoSuchUpload", http.StatusNotFound)
+			return
+		}
+
+		switch s.failComplete {
+		case 0:
+		case http.StatusOK:
+			fmt.Fprint(w, "<Error><Code>InternalError</Code><Message>We encountered an internal error.</Message></Error>")
+			return
+		default:
+			http.Error(w, "InternalError", s.failComplete)
+			return
+		}
+
+		var complete s3CompleteMultipartUpload
+		err = xml.Unmarshal(body, &complete)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var object []byte
+		for i, part := range complete.Parts {
+			data, ok := parts[part.PartNumber]
+			if part.PartNumber != i+1 || !ok || part.ETag != partETag(data) {
+				http.Error(w, "InvalidPart", http.StatusBadRequest)
+				return
+			}
+			object = append(object, data...)
+		}
+		s.objects[key] = object
+		delete(s.uploads, query.Get("uploadId"))
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><Key>%s</Key></CompleteMultipartUploadResult>", key)
+
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(s.uploads, query.Get("uploadId"))
+		s.aborted++
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut:
+		s.objects[key] = body
+
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		object, ok := s.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Write(object)
+
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "NotImplemented", http.StatusNotImplemented)
+	}
+}
+
+func partETag(data []byte) string {
+	hash := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(hash[:8]) + `"`
+}
+
+// checkSignature recomputes the AWS signature version 4 of a request from
+// what was received
+func checkSignature(r *http.Request, body []byte) error {
+	payloadHash := sha256.Sum256(body)
+	if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(payloadHash[:]) {
+		return fmt.Errorf("Payload hash does not match")
+	}
+
+	var credential, signedHeaders, signature string
+	auth := strings.TrimPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	for _, field := range strings.Split(auth, ", ") {
+		pair := strings.SplitN(field, "=", 2)
+		if len(pair) != 2 {
+			continue
+		}
+
+		switch pair[0] {
+		case "Credential":
+			credential = pair[1]
+		case "SignedHeaders":
+			signedHeaders = pair[1]
+		case "Signature":
+			signature = pair[1]
+		}
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	if len(amzDate) != 16 {
+		return fmt.Errorf("Missing X-Amz-Date")
+	}
+	scope := amzDate[:8] + "/" + testRegion + "/s3/aws4_request"
+	if credential != testAccessKey+"/"+scope {
+		return fmt.Errorf("Invalid credential %q", credential)
+	}
+
+	names := strings.Split(signedHeaders, ";")
+	if !sort.StringsAreSorted(names) || names[0] != "host" {
+		return fmt.Errorf("Invalid signed headers %q", signedHeaders)
+	}
+	for _, required := range []string{"x-amz-content-sha256", "x-amz-date"} {
+		if !strings.Contains(signedHeaders, required) {
+			return fmt.Errorf("%s is not signed", required)
+		}
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + value + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		s3Query(r.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+testSecretKey), amzDate[:8])
+	key = hmacSHA256(key, testRegion)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	expected := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return fmt.Errorf("Signature does not match")
+	}
+
+	return nil
+}
+
+func newTestS3(t *testing.T, secretKey string) (*fakeS3, S3Handlers) {
+	s3 := newFakeS3()
+	server := httptest.NewServer(s3)
+	t.Cleanup(server.Close)
+
+	handlers := GetS3Handlers(S3Config{
+		Endpoint:     server.URL,
+		Region:       testRegion,
+		Bucket:       testBucket,
+		Prefix:       "blocks",
+		AccessKey:    testAccessKey,
+		SecretKey:    secretKey,
+		SessionToken: "session",
+		PathStyle:    true,
+	})
+	return s3, handlers
+}
+
+func TestS3SinglePart(t *testing.T) {
+	s3, handlers := newTestS3(t, testSecretKey)
+	ctx := context.Background()
+
+	exists, err := handlers.Exists(ctx, "abc")
+	if err != nil || exists {
+		t.Fatalf("Exists before upload = %v, %v", exists, err)
+	}
+
+	data := []byte("encrypted block")
+	err = handlers.Upload(ctx, "abc", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s3.objects["/"+testBucket+"/blocks/abc"]; !ok {
+		t.Fatalf("Object not stored under the bucket and prefix: %v", s3.objects)
+	}
+
+	exists, err = handlers.Exists(ctx, "abc")
+	if err != nil || !exists {
+		t.Fatalf("Exists after upload = %v, %v", exists, err)
+	}
+
+	downloaded, err := handlers.Download(ctx, "abc")
+	if err != nil || !bytes.Equal(downloaded, data) {
+		t.Fatalf("Download = %q, %v", downloaded, err)
+	}
+
+	err = handlers.Delete(ctx, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = handlers.Download(ctx, "abc")
+	if err == nil {
+		t.Fatal("Download after delete succeeded")
+	}
+}
+
+func TestS3Multipart(t *testing.T) {
+	s3, handlers := newTestS3(t, testSecretKey)
+	ctx := context.Background()
+
+	data := bytes.Repeat([]byte("0123456789"), MinS3PartSize/10*2+7)
+	err := handlers.Upload(ctx, "large", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := 0
+	for _, request := range s3.requests {
+		if strings.HasPrefix(request, "PUT partNumber=") {
+			parts++
+		}
+	}
+	if parts != 3 {
+		t.Fatalf("Uploaded %d parts, expected 3: %v", parts, s3.requests)
+	}
+
+	downloaded, err := handlers.Download(ctx, "large")
+	if err != nil || !bytes.Equal(downloaded, data) {
+		t.Fatalf("Download of multipart upload failed: %v", err)
+	}
+}
+
+func TestS3MultipartAbort(t *testing.T) {
+	s3, handlers := newTestS3(t, testSecretKey)
+	s3.failPart = 2
+
+	data := bytes.Repeat([]byte{1}, MinS3PartSize*2)
+	err := handlers.Upload(context.Background(), "large", data)
+	if err == nil {
+		t.Fatal("Upload with a failing part succeeded")
+	}
+
+	if s3.aborted != 1 || len(s3.uploads) != 0 {
+		t.Fatalf("Multipart upload not aborted: %d aborted, %d open", s3.aborted, len(s3.uploads))
+	}
+
+	if len(s3.objects) != 0 {
+		t.Fatal("Failed upload stored an object")
+	}
+}
+
+func TestS3MultipartCompleteAbort(t *testing.T) {
+	for _, status := range []int{http.StatusInternalServerError, http.StatusOK} {
+		s3, handlers := newTestS3(t, testSecretKey)
+		s3.failComplete = status
+
+		data := bytes.Repeat([]byte{1}, MinS3PartSize*2)
+		err := handlers.Upload(context.Background(), "large", data)
+		if err == nil {
+			t.Fatalf("Upload failing to complete with %d succeeded", status)
+		}
+
+		if s3.aborted != 1 || len(s3.uploads) != 0 {
+			t.Fatalf("Multipart upload failing to complete with %d not aborted: %d aborted, %d open", status, s3.aborted, len(s3.uploads))
+		}
+	}
+}
+
+func TestS3Signature(t *testing.T) {
+	_, handlers := newTestS3(t, "wrong secret")
+
+	err := handlers.Upload(context.Background(), "abc", []byte("data"))
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("Upload with the wrong secret = %v, expected 403", err)
+	}
+
+	_, err = handlers.Exists(context.Background(), "abc")
+	if err == nil {
+		t.Fatal("Exists with the wrong secret did not fail")
+	}
+}
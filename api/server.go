@@ -7,17 +7,26 @@ import (
 	"os"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/beritani/whitebox/client"
+	"github.com/beritani/whitebox/core"
 	"github.com/gorilla/mux"
 )
 
 var (
-	envHost string
-	envPort string
-	envData string
-	envSize int
-	clients  map[string]*Client
+	envHost      string
+	envPort      string
+	envData      string
+	envSize      int
+	envWorkers   int
+	envVerify    client.VerifyPolicy
+	envOptions   core.Options
+	envRetention time.Duration
+	envRevisions int
+	envHandlers  client.ContextHandlers
+	clients      map[string]*Client
+	clientsMutex sync.RWMutex
 )
 
 // Client ...
@@ -46,10 +55,76 @@ func getEnv(key string, fallback string) string {
 
 func getClient(r *http.Request) *Client {
 	sessionID := r.Header.Get("X-Session-Id")
+	clientsMutex.RLock()
+	defer clientsMutex.RUnlock()
 	client := clients[sessionID]
 	return client
 }
 
+// purgeTrash purges expired files from the trash of every session each
+// interval
+func purgeTrash(interval time.Duration) {
+	for range time.Tick(interval) {
+		clientsMutex.RLock()
+		sessions := make([]*Client, 0, len(clients))
+		for _, c := range clients {
+			sessions = append(sessions, c)
+		}
+		clientsMutex.RUnlock()
+
+		for _, c := range sessions {
+			c.Lock()
+			purged, err := c.PurgeExpired()
+			c.Unlock()
+			if err != nil {
+				log.Printf("Purging trash of %s: purged %d files, %v", c.ID(), purged, err)
+			} else if purged > 0 {
+				log.Printf("Purged %d files from the trash of %s", purged, c.ID())
+			}
+		}
+	}
+}
+
+func getHandlers() (client.ContextHandlers, error) {
+	handlers, err := getStorageHandlers()
+	if err != nil {
+		return nil, err
+	}
+
+	if getEnv("VERIFY_KEY_FILES", "false") == "true" {
+		handlers = GetVerifiedHandlers(handlers)
+	}
+
+	return handlers, nil
+}
+
+func getStorageHandlers() (client.ContextHandlers, error) {
+	switch storage := getEnv("STORAGE", "local"); storage {
+	case "local":
+		return client.WithContext(GetLocalHandlers(envData)), nil
+	case "s3":
+		partSize, err := strconv.ParseInt(getEnv("S3_PART_SIZE", strconv.Itoa(MinS3PartSize)), 10, 0)
+		if err != nil {
+			return nil, fmt.Errorf("S3_PART_SIZE must be a number")
+		}
+		return GetS3Handlers(S3Config{
+			Endpoint:     getEnv("S3_ENDPOINT", "https://s3.amazonaws.com"),
+			Region:       getEnv("S3_REGION", "us-east-1"),
+			Bucket:       getEnv("S3_BUCKET", "whitebox"),
+			Prefix:       getEnv("S3_PREFIX", ""),
+			AccessKey:    getEnv("S3_ACCESS_KEY", ""),
+			SecretKey:    getEnv("S3_SECRET_KEY", ""),
+			SessionToken: getEnv("S3_SESSION_TOKEN", ""),
+			PathStyle:    getEnv("S3_PATH_STYLE", "false") == "true",
+			PartSize:     int(partSize),
+		}), nil
+	case "remote":
+		return GetRemoteHandlers(getEnv("REMOTE_URL", "http://localhost:8081"), getEnv("REMOTE_TOKEN", "")), nil
+	default:
+		return nil, fmt.Errorf("Unknown STORAGE %q", storage)
+	}
+}
+
 // CORSRouterDecorator applies CORS headers to a mux.Router
 type CORSRouterDecorator struct {
 	R *mux.Router
@@ -59,7 +134,8 @@ func (c *CORSRouterDecorator) ServeHTTP(rw http.ResponseWriter, req *http.Reques
 	if origin := req.Header.Get("Origin"); origin != "" {
 		rw.Header().Set("Access-Control-Allow-Origin", origin)
 		rw.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-		rw.Header().Set("Access-Control-Allow-Headers", "Accept, Accept-Language, Content-Type, X-Session-Id")
+		rw.Header().Set("Access-Control-Allow-Headers", "Accept, Accept-Language, Content-Type, Range, If-Range, X-Session-Id")
+		rw.Header().Set("Access-Control-Expose-Headers", "Accept-Ranges, Content-Length, Content-Range, Content-Type, ETag")
 	}
 	// Stop here if its Preflighted OPTIONS request
 	if req.Method == "OPTIONS" {
@@ -88,13 +164,23 @@ func handleRequests() {
 	api := verified.PathPrefix("/api").Subrouter()
 	api.HandleFunc("/pwd", pwd).Methods("POST")
 	api.HandleFunc("/upload", upload).Methods("POST")
-	api.HandleFunc("/download", download).Methods("POST")
+	api.HandleFunc("/download", download).Methods("GET", "POST")
 	api.HandleFunc("/info", info).Methods("POST")
 	api.HandleFunc("/mkdir", mkdir).Methods("POST")
 	api.HandleFunc("/cd", cd).Methods("POST")
 	api.HandleFunc("/ls", ls).Methods("POST")
 	api.HandleFunc("/refresh", refresh).Methods("POST")
 	api.HandleFunc("/rm", rm).Methods("POST")
+	api.HandleFunc("/rename", rename).Methods("POST")
+	api.HandleFunc("/mv", mv).Methods("POST")
+	api.HandleFunc("/cp", cp).Methods("POST")
+	api.HandleFunc("/update", update).Methods("POST")
+	api.HandleFunc("/revisions", revisions).Methods("POST")
+	api.HandleFunc("/rollback", rollback).Methods("POST")
+	api.HandleFunc("/trash", trash).Methods("POST")
+	api.HandleFunc("/trash/restore", restore).Methods("POST")
+	api.HandleFunc("/trash/purge", purge).Methods("POST")
+	api.HandleFunc("/repair", repair).Methods("POST")
 	api.HandleFunc("/publickey", publickey).Methods("POST")
 	api.HandleFunc("/query", query).Methods("POST")
 
@@ -114,7 +200,63 @@ func Start() {
 		log.Fatal("SIZE must be a number greater than 0")
 	}
 	envSize = int(size)
+	workers, err := strconv.ParseInt(getEnv("WORKERS", strconv.Itoa(client.DefaultWorkers)), 10, 0)
+	if err != nil || workers <= 0 {
+		log.Fatal("WORKERS must be a number greater than 0")
+	}
+	envWorkers = int(workers)
+	envVerify, err = client.ParseVerifyPolicy(getEnv("VERIFY", "strict"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	envOptions.Compression, err = core.ParseCompression(getEnv("COMPRESSION", "none"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	envOptions.Chunking, err = core.ParseChunking(getEnv("CHUNKING", "fixed"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	parity, err := strconv.ParseInt(getEnv("PARITY_SHARDS", "0"), 10, 0)
+	if err != nil || parity < 0 {
+		log.Fatal("PARITY_SHARDS must be a number of 0 or more")
+	}
+	envOptions.ParityShards = int(parity)
+	shards, err := strconv.ParseInt(getEnv("DATA_SHARDS", strconv.Itoa(core.DefaultDataShards)), 10, 0)
+	if err != nil || shards <= 0 || shards+parity > 256 {
+		log.Fatal("DATA_SHARDS must be a number greater than 0, with at most 256 shards in total")
+	}
+	envOptions.DataShards = int(shards)
+	envOptions.Padding, err = core.ParsePadding(getEnv("PADDING", "none"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	paddingBlocks, err := strconv.ParseInt(getEnv("PADDING_BLOCKS", strconv.Itoa(core.DefaultPaddingBlocks)), 10, 0)
+	if err != nil || paddingBlocks <= 0 {
+		log.Fatal("PADDING_BLOCKS must be a number greater than 0")
+	}
+	envOptions.PaddingBlocks = int(paddingBlocks)
+	envRetention, err = time.ParseDuration(getEnv("TRASH_RETENTION", "0s"))
+	if err != nil || envRetention < 0 {
+		log.Fatal("TRASH_RETENTION must be a duration of 0s or more, such as 720h")
+	}
+	revisions, err := strconv.ParseInt(getEnv("REVISIONS", strconv.Itoa(client.DefaultRevisions)), 10, 0)
+	if err != nil || revisions < 0 {
+		log.Fatal("REVISIONS must be a number of 0 or more")
+	}
+	envRevisions = int(revisions)
+	purgeInterval, err := time.ParseDuration(getEnv("PURGE_INTERVAL", "1h"))
+	if err != nil || purgeInterval <= 0 {
+		log.Fatal("PURGE_INTERVAL must be a duration greater than 0, such as 1h")
+	}
+	envHandlers, err = getHandlers()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	clients = map[string]*Client{}
+	if envRetention > 0 {
+		go purgeTrash(purgeInterval)
+	}
 	handleRequests()
 }
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/beritani/whitebox/client"
+	"github.com/beritani/whitebox/core"
+)
+
+// ErrRejected is returned when storage refuses to replace a key file
+var ErrRejected = errors.New("Key file replacement not signed by owner")
+
+// VerifiedHandlers only accepts a key file over an existing one if it is
+// signed by the next owner of the existing key file, or for key files written
+// before owners by the file key, and never deletes key files, so knowing the
+// ID of a key file is not enough to overwrite or delete it. Key files are not
+// accepted over blocks, but anyone may store a key file signed by itself at a
+// free ID, which is then kept. Blocks are not protected, as storage cannot
+// tell which file a block belongs to: anyone who knows a block ID can
+// overwrite or delete it. Downloads detect tampered blocks and parity can
+// rebuild some, but the data may be lost.
+type VerifiedHandlers struct {
+	handlers client.ContextHandlers
+	mutex    *sync.Mutex
+}
+
+// GetVerifiedHandlers ...
+func GetVerifiedHandlers(handlers client.ContextHandlers) VerifiedHandlers {
+	return VerifiedHandlers{
+		handlers: handlers,
+		mutex:    &sync.Mutex{},
+	}
+}
+
+// getKeyFile returns the key file stored at id, or nil for other blobs, and
+// whether anything is stored at id
+func (h VerifiedHandlers) getKeyFile(ctx context.Context, id string) (*core.KeyFile, bool, error) {
+	exists, err := h.handlers.Exists(ctx, id)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+
+	data, err := h.handlers.Download(ctx, id)
+	if err != nil {
+		return nil, true, err
+	}
+
+	keyFile, err := core.UnmarshalKeyFile(data)
+	if err != nil {
+		return nil, true, nil
+	}
+
+	return &keyFile, true, nil
+}
+
+// Upload ...
+func (h VerifiedHandlers) Upload(ctx context.Context, id string, data []byte) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	previous, exists, err := h.getKeyFile(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	keyFile, err := core.UnmarshalKeyFile(data)
+	switch {
+	case previous != nil && err != nil:
+		// Blob Replacing Key File
+		return ErrRejected
+	case exists && previous == nil && err == nil:
+		// Key File Replacing Block, which Could Not be Deleted
+		return ErrRejected
+	case previous != nil:
+		valid, err := keyFile.VerifyReplacement(id, previous)
+		if err != nil || !valid {
+			return ErrRejected
+		}
+	case err == nil && len(keyFile.Proof) > 0:
+		valid, err := keyFile.VerifyProof()
+		if err != nil || !valid {
+			return ErrRejected
+		}
+	}
+
+	return h.handlers.Upload(ctx, id, data)
+}
+
+// Download ...
+func (h VerifiedHandlers) Download(ctx context.Context, id string) ([]byte, error) {
+	return h.handlers.Download(ctx, id)
+}
+
+// Delete ...
+func (h VerifiedHandlers) Delete(ctx context.Context, id string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	keyFile, _, err := h.getKeyFile(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if keyFile != nil {
+		return ErrRejected
+	}
+
+	return h.handlers.Delete(ctx, id)
+}
+
+// Exists ...
+func (h VerifiedHandlers) Exists(ctx context.Context, id string) (bool, error) {
+	return h.handlers.Exists(ctx, id)
+}
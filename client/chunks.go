@@ -0,0 +1,205 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/beritani/whitebox/core"
+)
+
+// Reference records list the files that use each content-defined chunk, so
+// a chunk is only deleted with the last file that uses it. Records are read,
+// changed and written back, so an account should only be changed by one
+// client at a time.
+
+func (c *Client) getChunkKeys() (*core.ChunkKeys, error) {
+	if c.chunkKeys != nil {
+		return c.chunkKeys, nil
+	}
+
+	keys, err := core.NewChunkKeys(c.masterKey)
+	if err != nil {
+		return nil, err
+	}
+	c.chunkKeys = keys
+
+	return keys, nil
+}
+
+// uploadChunk uploads a chunk unless it is already stored, returning true if
+// it was uploaded
+func (c *Client) uploadChunk(ctx context.Context, block core.EncryptedBlock) (bool, error) {
+	exists, err := c.handlers.Exists(ctx, block.ID)
+	if err != nil || exists {
+		return false, err
+	}
+
+	err = c.handlers.Upload(ctx, block.ID, block.Data)
+	return err == nil, err
+}
+
+func (c *Client) getChunkRefs(ctx context.Context, keys *core.ChunkKeys, chunkID string) ([]string, error) {
+	refID := keys.RefID(chunkID)
+	exists, err := c.handlers.Exists(ctx, refID)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	data, err := c.handlers.Download(ctx, refID)
+	if err != nil {
+		return nil, err
+	}
+
+	return keys.DecryptRefs(chunkID, data)
+}
+
+// addChunkRefs records owner as a user of each chunk
+func (c *Client) addChunkRefs(ctx context.Context, keys *core.ChunkKeys, owner string, chunkIDs []string) error {
+	p := newPool(ctx, c.Workers)
+	for _, chunkID := range chunkIDs {
+		chunkID := chunkID
+		err := p.Go(chunkID, func(ctx context.Context) error {
+			owners, err := c.getChunkRefs(ctx, keys, chunkID)
+			if err != nil {
+				return err
+			}
+
+			for _, o := range owners {
+				if o == owner {
+					return nil
+				}
+			}
+
+			data, err := keys.EncryptRefs(chunkID, append(owners, owner))
+			if err != nil {
+				return err
+			}
+
+			return c.handlers.Upload(ctx, keys.RefID(chunkID), data)
+		})
+		if err != nil {
+			break
+		}
+	}
+
+	return p.Wait()
+}
+
+// releaseChunks removes owner as a user of each chunk, deleting chunks
+// without any other users. Chunks without a record are left, as their
+// record may be lost or not written yet. If created is not nil, only the
+// chunks in it are deleted, with or without a record, as a failed upload
+// created them.
+func (c *Client) releaseChunks(ctx context.Context, keys *core.ChunkKeys, owner string, chunkIDs []string, created map[string]bool) error {
+	p := newPool(ctx, c.Workers)
+	for _, chunkID := range chunkIDs {
+		chunkID := chunkID
+		deletable := created == nil || created[chunkID]
+		err := p.Go(chunkID, func(ctx context.Context) error {
+			owners, err := c.getChunkRefs(ctx, keys, chunkID)
+			if err != nil {
+				return err
+			}
+
+			if owners == nil {
+				if created[chunkID] {
+					return c.handlers.Delete(ctx, chunkID)
+				}
+				return nil
+			}
+
+			remaining := []string{}
+			for _, o := range owners {
+				if o != owner {
+					remaining = append(remaining, o)
+				}
+			}
+
+			if len(remaining) > 0 {
+				data, err := keys.EncryptRefs(chunkID, remaining)
+				if err != nil {
+					return err
+				}
+				return c.handlers.Upload(ctx, keys.RefID(chunkID), data)
+			}
+
+			// Delete Chunk before its Record, so it is never Unrecorded
+			if deletable {
+				err = c.handlers.Delete(ctx, chunkID)
+				if err != nil {
+					return err
+				}
+			}
+
+			return c.handlers.Delete(ctx, keys.RefID(chunkID))
+		})
+		if err != nil {
+			break
+		}
+	}
+
+	return p.Wait()
+}
+
+// getManifest returns the chunks of a content-chunked file
+func (c *Client) getManifest(ctx context.Context, set blockSet) (core.Manifest, error) {
+	var buffer bytes.Buffer
+	_, err := c.writeBlocks(ctx, set, &buffer)
+	if err != nil {
+		return core.Manifest{}, err
+	}
+
+	return core.ParseManifest(buffer.Bytes())
+}
+
+func (c *Client) getChunk(ctx context.Context, ref core.ChunkRef) ([]byte, error) {
+	data, err := c.handlers.Download(ctx, ref.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return ref.Decrypt(core.EncryptedBlock{ID: ref.ID, Data: data})
+}
+
+// writeChunks writes the chunks of manifest in order into w, downloading
+// one window of chunks per worker at a time
+func (c *Client) writeChunks(ctx context.Context, manifest core.Manifest, w io.Writer) (int64, error) {
+	var written int64
+	for next := 0; next < len(manifest.Chunks); {
+		end := next + c.Workers
+		if c.Workers < 1 || end > len(manifest.Chunks) {
+			end = len(manifest.Chunks)
+		}
+
+		// Fetch Window of Chunks
+		chunks := make([][]byte, end-next)
+		p := newPool(ctx, c.Workers)
+		for i := next; i < end; i++ {
+			i := i
+			err := p.Go(manifest.Chunks[i].ID, func(ctx context.Context) (err error) {
+				chunks[i-next], err = c.getChunk(ctx, manifest.Chunks[i])
+				return err
+			})
+			if err != nil {
+				break
+			}
+		}
+
+		err := p.Wait()
+		if err != nil {
+			return written, err
+		}
+
+		for _, chunk := range chunks {
+			n, err := w.Write(chunk)
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+		}
+		next = end
+	}
+
+	return written, nil
+}
@@ -1,17 +1,61 @@
 package client
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/beritani/whitebox/core"
 	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 	"github.com/decred/dcrd/hdkeychain/v3"
 )
 
+// ErrNotExist is returned when a file has no key file in storage
+var ErrNotExist = errors.New("File does not exist")
+
+// ListError reports the files in a folder that could not be read, by index.
+// It unwraps to the error of the first of them.
+type ListError struct {
+	Path string
+	Errs map[uint32]error
+}
+
+func (e *ListError) Error() string {
+	failures := []string{}
+	for _, index := range e.indices() {
+		failures = append(failures, fmt.Sprintf("#%d: %v", index, e.Errs[index]))
+	}
+	return fmt.Sprintf("Could not read %d files in %q: %s", len(e.Errs), e.Path, strings.Join(failures, "; "))
+}
+
+// Unwrap returns the error of the first file that could not be read
+func (e *ListError) Unwrap() error {
+	return e.Errs[e.indices()[0]]
+}
+
+func (e *ListError) indices() []uint32 {
+	indices := []uint32{}
+	for index := range e.Errs {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	return indices
+}
+
+// MaxIndexGap is the number of indices in a row without a key file after
+// which a folder is assumed to have no more files
+const MaxIndexGap = 16
+
 // Handlers Abstract Interface
 type Handlers interface {
 	Upload(id string, data []byte) error
@@ -41,11 +85,17 @@ type Folder struct {
 type Client struct {
 	Mnemonic  string
 	Size      int
+	Workers   int
+	Verify    VerifyPolicy
+	Options   core.Options
+	Retention time.Duration
+	Revisions int
 	mutex     *sync.Mutex
 	masterKey *hdkeychain.ExtendedKey
+	chunkKeys *core.ChunkKeys
 	pwd       *Folder
 	root      *Folder
-	handlers  Handlers
+	handlers  ContextHandlers
 }
 
 // ID returns a hash of the public key
@@ -54,11 +104,136 @@ func (c *Client) ID() string {
 	return core.KeyID(publicKey)
 }
 
-func (c *Client) getBlock(key []byte, fileID string, index int) (core.Block, error) {
-	blockID := core.BlockID(fileID, index)
-	blockData, err := c.handlers.Download(blockID)
+// blockSet identifies the meta or file blocks of a key file
+type blockSet struct {
+	key    []byte
+	fileID string
+	count  int
+	bound  bool
+	data   int
+	parity int
+	root   []byte
+	tree   int
+	leaves [][]byte
+}
+
+func newBlockSet(keyFile *core.KeyFile, key []byte, salt []byte, count int) (blockSet, error) {
+	publicKey, err := keyFile.PublicKey()
 	if err != nil {
-		return core.Block{}, err
+		return blockSet{}, err
+	}
+
+	return blockSet{
+		key:    key,
+		fileID: core.FileID(publicKey, salt),
+		count:  count,
+		bound:  keyFile.Format >= core.FormatBound,
+	}, nil
+}
+
+func metaBlockSet(keyFile *core.KeyFile) (blockSet, error) {
+	layout, err := keyFile.GetLayout()
+	if err != nil {
+		return blockSet{}, err
+	}
+
+	set, err := newBlockSet(keyFile, keyFile.MetaKey(), keyFile.MetaSalt, layout.MetaBlocks)
+	set.data, set.parity = layout.DataShards, layout.ParityShards
+	return set, err
+}
+
+func fileBlockSet(keyFile *core.KeyFile) (blockSet, error) {
+	layout, err := keyFile.GetLayout()
+	if err != nil {
+		return blockSet{}, err
+	}
+
+	set, err := newBlockSet(keyFile, keyFile.FileKey(), keyFile.FileSalt, layout.FileBlocks)
+	set.data, set.parity = layout.DataShards, layout.ParityShards
+	set.root, set.tree = layout.Root, layout.TreeBlocks
+	return set, err
+}
+
+// treeSet returns the blocks storing the Merkle leaf hashes of a file set
+func (s blockSet) treeSet() blockSet {
+	return blockSet{
+		key:    s.key,
+		fileID: core.TreeID(s.fileID),
+		count:  s.tree,
+		bound:  true,
+		data:   s.data,
+		parity: s.parity,
+	}
+}
+
+// loadTree downloads the leaf hashes of a file set and checks them against
+// the signed Merkle root, so every block downloaded after is checked too
+func (c *Client) loadTree(ctx context.Context, set *blockSet) error {
+	if set.root == nil {
+		return nil
+	}
+
+	blocks, err := c.getBlocks(ctx, set.treeSet())
+	if err != nil {
+		return err
+	}
+
+	tree := core.RecreateFile(blocks)
+	if len(tree) != set.count*sha256.Size {
+		return fmt.Errorf("File %s has %d leaf hashes, expected %d", set.fileID, len(tree)/sha256.Size, set.count)
+	}
+
+	leaves := make([][]byte, set.count)
+	for i := range leaves {
+		leaves[i] = tree[i*sha256.Size : (i+1)*sha256.Size]
+	}
+
+	if !bytes.Equal(core.MerkleRoot(leaves), set.root) {
+		return fmt.Errorf("File %s does not match its Merkle root", set.fileID)
+	}
+	set.leaves = leaves
+
+	return nil
+}
+
+// resolveCount reads the block count of legacy block sets from their first
+// block, which is returned so it is not downloaded twice
+func (c *Client) resolveCount(ctx context.Context, set *blockSet) (*core.Block, error) {
+	if set.bound {
+		return nil, nil
+	}
+
+	block0, err := c.getBlock(ctx, *set, 0)
+	if err != nil {
+		return nil, err
+	}
+	set.count = block0.Count
+
+	return &block0, nil
+}
+
+// getBlock downloads and decrypts a block, rebuilding it from the rest of
+// its group if it is missing or damaged and the set has parity blocks
+func (c *Client) getBlock(ctx context.Context, set blockSet, index int) (core.Block, error) {
+	blockID := core.BlockID(set.fileID, index)
+	blockData, err := c.handlers.Download(ctx, blockID)
+
+	var block core.Block
+	if err == nil {
+		block, err = decryptBlock(set, index, blockData)
+	}
+
+	if err != nil && set.parity > 0 && ctx.Err() == nil {
+		return c.recoverBlock(ctx, set, index, err)
+	}
+
+	return block, err
+}
+
+func decryptBlock(set blockSet, index int, blockData []byte) (core.Block, error) {
+	blockID := core.BlockID(set.fileID, index)
+	if set.leaves != nil && !bytes.Equal(core.MerkleLeaf(blockData), set.leaves[index]) {
+		return core.Block{}, fmt.Errorf("Block %s does not match its Merkle leaf", blockID)
 	}
 
 	encryptedBlock := core.EncryptedBlock{
@@ -66,49 +241,96 @@ func (c *Client) getBlock(key []byte, fileID string, index int) (core.Block, err
 		Data: blockData,
 	}
 
-	block, err := encryptedBlock.Decrypt(key)
+	if !set.bound {
+		return encryptedBlock.Decrypt(set.key)
+	}
+
+	block, err := encryptedBlock.DecryptWithAD(set.key, core.BlockAD(set.fileID, blockID, index, set.count))
 	if err != nil {
 		return core.Block{}, err
 	}
 
+	if block.Count != set.count {
+		return core.Block{}, fmt.Errorf("Block %s has count %d, expected %d", blockID, block.Count, set.count)
+	}
+
 	return block, nil
 }
 
-func (c *Client) getBlocks(key []byte, fileID string) ([]core.Block, error) {
-	block0, err := c.getBlock(key, fileID, 0)
+func (c *Client) getBlocks(ctx context.Context, set blockSet) ([]core.Block, error) {
+	block0, err := c.resolveCount(ctx, &set)
 	if err != nil {
 		return nil, err
 	}
 
-	blocks := make([]core.Block, block0.Count)
-	blocks[0] = block0
+	if block0 == nil {
+		return c.getBlockRange(ctx, set, 0, set.count)
+	}
 
-	for i := 1; i < block0.Count; i++ {
-		block, err := c.getBlock(key, fileID, i)
+	blocks, err := c.getBlockRange(ctx, set, 1, set.count)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]core.Block{*block0}, blocks...), nil
+}
+
+// getBlockRange downloads blocks [start, end) concurrently, keeping their order
+func (c *Client) getBlockRange(ctx context.Context, set blockSet, start int, end int) ([]core.Block, error) {
+	if end <= start {
+		return nil, nil
+	}
+
+	blocks := make([]core.Block, end-start)
+	p := newPool(ctx, c.Workers)
+	for i := start; i < end; i++ {
+		i := i
+		err := p.Go(core.BlockID(set.fileID, i), func(ctx context.Context) (err error) {
+			blocks[i-start], err = c.getBlock(ctx, set, i)
+			return err
+		})
 		if err != nil {
-			return nil, err
+			break
 		}
-		blocks[i] = block
+	}
+
+	err := p.Wait()
+	if err != nil {
+		return nil, err
 	}
 
 	return blocks, nil
 }
 
-func (c *Client) getBlockIds(key []byte, fileID string) ([]string, error) {
-	block0, err := c.getBlock(key, fileID, 0)
+func (c *Client) getBlockIds(ctx context.Context, set blockSet) ([]string, error) {
+	_, err := c.resolveCount(ctx, &set)
 	if err != nil {
 		return nil, err
 	}
 
-	blockIDs := make([]string, block0.Count)
-	for i := 0; i < block0.Count; i++ {
-		blockIDs[i] = core.BlockID(fileID, i)
+	blockIDs := make([]string, set.count)
+	for i := 0; i < set.count; i++ {
+		blockIDs[i] = core.BlockID(set.fileID, i)
+	}
+
+	for group := 0; group < set.groups(); group++ {
+		for i := 0; i < set.parity; i++ {
+			blockIDs = append(blockIDs, core.ParityID(set.fileID, group, i))
+		}
+	}
+
+	if set.tree > 0 {
+		treeIDs, err := c.getBlockIds(ctx, set.treeSet())
+		if err != nil {
+			return nil, err
+		}
+		blockIDs = append(blockIDs, treeIDs...)
 	}
 
 	return blockIDs, nil
 }
 
-func (c *Client) getKeyFile(parent *Folder, index uint32) (*core.KeyFile, error) {
+func (c *Client) getKeyFile(ctx context.Context, parent *Folder, index uint32) (*core.KeyFile, error) {
 	// Check Key File Exists
 	file := parent.Children[index]
 	if !file.KeyFile.MissingData() {
@@ -137,11 +359,16 @@ func (c *Client) getKeyFile(parent *Folder, index uint32) (*core.KeyFile, error)
 
 	// Get Key File
 	keyID := core.KeyID(file.PublicKey)
-	if !c.handlers.Exists(keyID) {
+	exists, err := c.handlers.Exists(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
 		return nil, nil
 	}
 
-	keyData, err := c.handlers.Download(keyID)
+	keyData, err := c.handlers.Download(ctx, keyID)
 	if err != nil {
 		return nil, err
 	}
@@ -151,6 +378,11 @@ func (c *Client) getKeyFile(parent *Folder, index uint32) (*core.KeyFile, error)
 		return nil, err
 	}
 
+	err = c.checkKeyFile(&keyFile)
+	if err != nil {
+		return nil, err
+	}
+
 	// Save Key File
 	file.KeyFile = &keyFile
 	parent.Children[index] = file
@@ -158,7 +390,7 @@ func (c *Client) getKeyFile(parent *Folder, index uint32) (*core.KeyFile, error)
 	return file.KeyFile, nil
 }
 
-func (c *Client) getMeta(parent *Folder, index uint32) (*core.Meta, error) {
+func (c *Client) getMeta(ctx context.Context, parent *Folder, index uint32) (*core.Meta, error) {
 	// Check Already Exists
 	file := parent.Children[index]
 	if file.Meta != nil {
@@ -166,20 +398,12 @@ func (c *Client) getMeta(parent *Folder, index uint32) (*core.Meta, error) {
 	}
 
 	// Get Key File
-	keyFile, err := c.getKeyFile(parent, index)
+	keyFile, err := c.getKeyFile(ctx, parent, index)
 	if err != nil {
 		return nil, err
 	}
 
-	// Recreate Meta
-	metaID := core.FileID(file.PublicKey, keyFile.MetaSalt)
-	metaBlocks, err := c.getBlocks(keyFile.Key(), metaID)
-	if err != nil {
-		return nil, err
-	}
-
-	metaData := core.RecreateFile(metaBlocks)
-	metaFile, err := core.ParseMeta(metaData)
+	metaFile, err := c.readMeta(ctx, keyFile)
 	if err != nil {
 		return nil, err
 	}
@@ -191,6 +415,39 @@ func (c *Client) getMeta(parent *Folder, index uint32) (*core.Meta, error) {
 	return file.Meta, nil
 }
 
+// readMeta returns the meta data in the meta blocks of a key file, which is
+// empty for removed files
+func (c *Client) readMeta(ctx context.Context, keyFile *core.KeyFile) (core.Meta, error) {
+	metaSet, err := metaBlockSet(keyFile)
+	if err != nil {
+		return core.Meta{}, err
+	}
+
+	// Removed Files Have No Meta Data, or No First Block Before FormatBound
+	var metaBlocks []core.Block
+	removed := false
+	if !metaSet.bound {
+		exists, err := c.handlers.Exists(ctx, core.BlockID(metaSet.fileID, 0))
+		if err != nil {
+			return core.Meta{}, err
+		}
+		removed = !exists
+	}
+
+	if !removed {
+		metaBlocks, err = c.getBlocks(ctx, metaSet)
+		if err != nil {
+			return core.Meta{}, err
+		}
+	}
+
+	metaData := core.RecreateFile(metaBlocks)
+	if len(metaData) == 0 {
+		return core.Meta{}, nil
+	}
+	return core.ParseMeta(metaData)
+}
+
 func (c *Client) getPath(parent *Folder, index uint32) string {
 	file := parent.Children[index]
 	if file.Path == "" {
@@ -200,7 +457,7 @@ func (c *Client) getPath(parent *Folder, index uint32) string {
 	return file.Path
 }
 
-func (c *Client) getFileDetails(parent *Folder, index uint32) (*Folder, error) {
+func (c *Client) getFileDetails(ctx context.Context, parent *Folder, index uint32) (*Folder, error) {
 	// Check Already
 	if _, ok := parent.Children[index]; !ok {
 		parent.Children[index] = Folder{
@@ -212,24 +469,24 @@ func (c *Client) getFileDetails(parent *Folder, index uint32) (*Folder, error) {
 		}
 	}
 
-	keyFile, err := c.getKeyFile(parent, index)
+	keyFile, err := c.getKeyFile(ctx, parent, index)
 	if err != nil {
 		delete(parent.Children, index)
 		return nil, err
 	}
 
-	if keyFile == nil {
+	if keyFile == nil || isRemoved(keyFile) || isTrashed(keyFile) {
 		delete(parent.Children, index)
 		return nil, nil
 	}
 
-	meta, err := c.getMeta(parent, index)
+	meta, err := c.getMeta(ctx, parent, index)
 	if err != nil {
 		delete(parent.Children, index)
 		return nil, err
 	}
 
-	if meta == nil {
+	if meta == nil || meta.Type == "" {
 		delete(parent.Children, index)
 		return nil, nil
 	}
@@ -241,65 +498,217 @@ func (c *Client) getFileDetails(parent *Folder, index uint32) (*Folder, error) {
 	return &file, nil
 }
 
-func (c *Client) getChildCount(parent *Folder) (uint32, error) {
-	var i uint32 = 1
-	for true {
-		child, err := parent.Key.Child(i)
+// getChildCount returns the last index in parent with a key file, looking
+// past up to MaxIndexGap indices without one
+func (c *Client) getChildCount(ctx context.Context, parent *Folder) (uint32, error) {
+	var last uint32
+	for i := uint32(1); i-last <= MaxIndexGap; i++ {
+		exists, err := c.keyFileExists(ctx, parent, i)
 		if err != nil {
 			return 0, err
 		}
 
-		publicKey, err := core.GetPublicKeyFromHDKey(child)
+		if exists {
+			last = i
+		}
+	}
+
+	return last, nil
+}
+
+// keyFileExists returns true if there is a key file at index in parent,
+// whether or not it can be read
+func (c *Client) keyFileExists(ctx context.Context, parent *Folder, index uint32) (bool, error) {
+	child, err := parent.Key.Child(index)
+	if err != nil {
+		return false, err
+	}
+
+	publicKey, err := core.GetPublicKeyFromHDKey(child)
+	if err != nil {
+		return false, err
+	}
+
+	return c.handlers.Exists(ctx, core.KeyID(publicKey))
+}
+
+// nextIndex returns the first free index in parent and the version of a key
+// file there. Indices without a key file and those of removed files are
+// free, unless files remain in them from before folders were removed
+// recursively.
+func (c *Client) nextIndex(ctx context.Context, parent *Folder) (uint32, uint32, error) {
+	count, err := c.getChildCount(ctx, parent)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for i := uint32(1); i <= count; i++ {
+		version, free, err := c.slotVersion(ctx, parent, i)
 		if err != nil {
-			return 0, err
+			return 0, 0, err
 		}
 
-		id := core.KeyID(publicKey)
-		if !c.handlers.Exists(id) {
-			break
+		if free {
+			return i, version, nil
 		}
+	}
 
-		i++
+	return count + 1, 0, nil
+}
+
+// slotVersion returns whether index in parent is free and the version of a
+// key file there. Slots whose files cannot be read are not free, so only
+// storage and context errors are returned.
+func (c *Client) slotVersion(ctx context.Context, parent *Folder, index uint32) (uint32, bool, error) {
+	file, err := c.getFileDetails(ctx, parent, index)
+	if err != nil {
+		return 0, false, c.occupied(ctx, parent, index, err)
 	}
 
-	return i - 1, nil
+	if file != nil {
+		return 0, false, nil
+	}
+
+	// Removed Files are Not Listed
+	keyFile, err := c.getKeyFile(ctx, parent, index)
+	delete(parent.Children, index)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if keyFile == nil {
+		return 0, true, nil
+	}
+
+	if isTrashed(keyFile) {
+		return 0, false, nil
+	}
+
+	version, err := keyFile.GetVersion()
+	if err != nil {
+		return 0, false, err
+	}
+
+	// Files Left in Folders Removed One Level at a Time
+	slot, err := parent.Key.Child(index)
+	if err != nil {
+		return 0, false, err
+	}
+
+	removed := &Folder{Key: slot, Children: map[uint32]Folder{}}
+	removed.Parent = removed
+	children, err := c.LsContext(ctx, removed)
+	var listErr *ListError
+	if errors.As(err, &listErr) {
+		return 0, false, nil
+	}
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	return version + 1, len(children) == 0, nil
+}
+
+// occupied returns nil if the key file at index in parent, which failed to
+// be read with err, exists, and otherwise the error to return
+func (c *Client) occupied(ctx context.Context, parent *Folder, index uint32, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	exists, existsErr := c.keyFileExists(ctx, parent, index)
+	if existsErr != nil {
+		return existsErr
+	}
+
+	if !exists {
+		return err
+	}
+
+	return nil
+}
+
+// uploadFile uploads the blocks and then the key file of a file, deleting
+// the blocks it uploaded if it fails
+func (c *Client) uploadFile(ctx context.Context, file core.File) error {
+	uploaded, err := c.storeFile(ctx, file)
+	if err != nil {
+		return cleanUp(err, func(ctx context.Context) error {
+			return c.deleteBlocks(ctx, uploaded)
+		})
+	}
+	return nil
 }
 
-func (c *Client) uploadFile(file core.File) error {
+// storeFile is uploadFile returning the blocks it uploaded, even if it fails
+func (c *Client) storeFile(ctx context.Context, file core.File) ([]string, error) {
+	// Upload Meta and File Blocks
+	p := newPool(ctx, c.Workers)
+	for _, blocks := range [][]core.EncryptedBlock{file.MetaBlocks, file.FileBlocks} {
+		for _, block := range blocks {
+			block := block
+			err := p.Go(block.ID, func(ctx context.Context) error {
+				return c.handlers.Upload(ctx, block.ID, block.Data)
+			})
+			if err != nil {
+				break
+			}
+		}
+	}
+
+	err := p.Wait()
+	if err != nil {
+		return p.Done(), err
+	}
+
 	// Encrypt and Upload Key File
 	encryptedKeyFile := file.KeyFile.Encrypt()
 	keyID, err := encryptedKeyFile.ID()
 	if err != nil {
-		return err
+		return p.Done(), err
 	}
 
-	encryptedKeyFileData, err := encryptedKeyFile.Serialise()
+	// Only Key Files Replacing Others Need a Claim
+	version, err := file.KeyFile.GetVersion()
 	if err != nil {
-		return err
+		return p.Done(), err
 	}
 
-	err = c.handlers.Upload(keyID, encryptedKeyFileData)
+	if version > 0 {
+		err = c.claimReplacement(ctx, keyID, &encryptedKeyFile)
+		if err != nil {
+			return p.Done(), err
+		}
+	}
+
+	encryptedKeyFileData, err := encryptedKeyFile.Serialise()
 	if err != nil {
+		return p.Done(), err
+	}
+
+	return p.Done(), c.handlers.Upload(ctx, keyID, encryptedKeyFileData)
+}
+
+// claimReplacement claims an encrypted key file with the file key if it
+// replaces one stored at keyID before owners
+func (c *Client) claimReplacement(ctx context.Context, keyID string, encryptedKeyFile *core.KeyFile) error {
+	exists, err := c.handlers.Exists(ctx, keyID)
+	if err != nil || !exists {
 		return err
 	}
 
-	// Upload Meta Blocks
-	for _, block := range file.MetaBlocks {
-		err := c.handlers.Upload(block.ID, block.Data)
-		if err != nil {
-			return err
-		}
+	data, err := c.handlers.Download(ctx, keyID)
+	if err != nil {
+		return err
 	}
 
-	// Upload File Blocks
-	for _, block := range file.FileBlocks {
-		err := c.handlers.Upload(block.ID, block.Data)
-		if err != nil {
-			return err
-		}
+	previous, err := core.UnmarshalKeyFile(data)
+	if err != nil {
+		return nil
 	}
 
-	return nil
+	return encryptedKeyFile.AddClaim(&previous)
 }
 
 // Pwd ...
@@ -334,28 +743,37 @@ func (c *Client) GetExtendedPublicKey(file *Folder) string {
 
 // GetFolderFromPath ...
 func (c *Client) GetFolderFromPath(folder *Folder, path string) (*Folder, error) {
+	return c.GetFolderFromPathContext(context.Background(), folder, path)
+}
+
+// GetFolderFromPathContext resolves a path of names or indices, as described
+// in path.go, from folder or from the root if it starts with a slash
+func (c *Client) GetFolderFromPathContext(ctx context.Context, folder *Folder, path string) (*Folder, error) {
 	path = filepath.Clean(path)
 	if path[0] == '/' {
 		folder = c.Root()
 	}
 
-	for _, i := range strings.Split(path, "/") {
-		switch i {
+	for _, segment := range strings.Split(path, "/") {
+		switch segment {
 		case "":
 			continue
 		case ".":
 			continue
 		case "..":
-			folder = c.pwd.Parent
+			folder = folder.Parent
 		default:
-			index, err := strconv.ParseInt(i, 10, 0)
+			index, err := c.resolveSegment(ctx, folder, segment)
 			if err != nil {
 				return nil, err
 			}
-			folder, err = c.getFileDetails(folder, uint32(index))
+			folder, err = c.getFileDetails(ctx, folder, index)
 			if err != nil {
 				return nil, err
 			}
+			if folder == nil {
+				return nil, fmt.Errorf("%w: %q", ErrNotExist, segment)
+			}
 		}
 	}
 
@@ -364,13 +782,18 @@ func (c *Client) GetFolderFromPath(folder *Folder, path string) (*Folder, error)
 
 // Cd ...
 func (c *Client) Cd(path string) (*Folder, error) {
+	return c.CdContext(context.Background(), path)
+}
+
+// CdContext ...
+func (c *Client) CdContext(ctx context.Context, path string) (*Folder, error) {
 	path = filepath.Clean(path)
 
 	if path[0] == '/' {
 		c.pwd = c.root
 	}
 
-	folder, err := c.GetFolderFromPath(c.pwd, path)
+	folder, err := c.GetFolderFromPathContext(ctx, c.pwd, path)
 	if err != nil {
 		return nil, err
 	}
@@ -381,43 +804,126 @@ func (c *Client) Cd(path string) (*Folder, error) {
 
 // Ls ...
 func (c *Client) Ls(folder *Folder) map[uint32]Folder {
-	var i uint32 = 1
-	for true {
-		file, err := c.getFileDetails(folder, i)
+	children, _ := c.LsContext(context.Background(), folder)
+	return children
+}
+
+// LsContext lists the files in folder, skipping removed files and missing
+// indices. Files that cannot be read, such as those whose signature does not
+// verify, are left out and reported in a ListError.
+func (c *Client) LsContext(ctx context.Context, folder *Folder) (map[uint32]Folder, error) {
+	count, err := c.getChildCount(ctx, folder)
+	if err != nil {
+		return folder.Children, err
+	}
+
+	failed := map[uint32]error{}
+	for i := uint32(1); i <= count; i++ {
+		_, err := c.getFileDetails(ctx, folder, i)
 		if err != nil {
-			delete(folder.Children, i)
-			break
+			failed[i] = err
 		}
-		if file == nil {
-			delete(folder.Children, i)
-			break
+	}
+
+	for index := range folder.Children {
+		if index > count {
+			delete(folder.Children, index)
 		}
-		i++
 	}
-	return folder.Children
+
+	if ctx.Err() != nil {
+		return folder.Children, ctx.Err()
+	}
+
+	if len(failed) > 0 {
+		return folder.Children, &ListError{Path: folder.Path, Errs: failed}
+	}
+
+	return folder.Children, nil
 }
 
 // Refresh ...
 func (c *Client) Refresh(parent *Folder) {
-	parent.Children = map[uint32]Folder{}
-	c.Ls(parent)
+	c.RefreshContext(context.Background(), parent)
+}
+
+// RefreshContext ...
+func (c *Client) RefreshContext(ctx context.Context, parent *Folder) error {
+	// Clear in Place, as Copies of the Folder Share its Children
+	for index := range parent.Children {
+		delete(parent.Children, index)
+	}
+	_, err := c.LsContext(ctx, parent)
+	return err
+}
+
+// refresh is RefreshContext for changes to one file, which go ahead when
+// other files in parent cannot be read
+func (c *Client) refresh(ctx context.Context, parent *Folder) error {
+	err := c.RefreshContext(ctx, parent)
+	var listErr *ListError
+	if errors.As(err, &listErr) {
+		return nil
+	}
+	return err
+}
+
+// isRemoved returns true for the key files of removed files
+func isRemoved(keyFile *core.KeyFile) bool {
+	layout, err := keyFile.GetLayout()
+	return err == nil && layout.Removed
+}
+
+// describe fills in the times, mode, owner and MIME type of meta that were
+// not given. Size and SHA256 are set as the file is created.
+func (c *Client) describe(meta core.Meta) core.Meta {
+	now := time.Now().UTC()
+	if meta.Created == nil {
+		meta.Created = &now
+	}
+
+	if meta.Modified == nil {
+		meta.Modified = meta.Created
+	}
+
+	if meta.Owner == "" {
+		meta.Owner = c.ID()
+	}
+
+	if meta.Mode == 0 {
+		meta.Mode = 0644
+		if meta.Type == "folder" {
+			meta.Mode = 0755
+		}
+	}
+
+	if meta.MIME == "" && meta.Type == "file" {
+		meta.MIME = mime.TypeByExtension(filepath.Ext(meta.Name))
+	}
+
+	return meta
 }
 
 // Mkdir ...
 func (c *Client) Mkdir(parent *Folder, meta core.Meta) (*Folder, error) {
+	return c.MkdirContext(context.Background(), parent, meta)
+}
+
+// MkdirContext ...
+func (c *Client) MkdirContext(ctx context.Context, parent *Folder, meta core.Meta) (*Folder, error) {
 	meta.Type = "folder"
-	count, err := c.getChildCount(parent)
+	meta = c.describe(meta)
+	index, version, err := c.nextIndex(ctx, parent)
 	if err != nil {
 		return nil, err
 	}
-	index := count + 1
 
-	file, err := core.CreateFolder(parent.Key, index, meta, c.Size)
+	file, err := core.CreateFolderWithOptions(parent.Key, index, meta, c.Size, version, c.Options)
 	if err != nil {
 		return nil, err
 	}
 
-	err = c.uploadFile(file)
+	err = c.uploadFile(ctx, file)
 	if err != nil {
 		return nil, err
 	}
@@ -440,125 +946,301 @@ func (c *Client) Mkdir(parent *Folder, meta core.Meta) (*Folder, error) {
 
 // Rm ...
 func (c *Client) Rm(folder *Folder) error {
-	c.Refresh(folder.Parent)
+	return c.RmContext(context.Background(), folder)
+}
+
+// RmContext moves a file, or a folder and everything in it, to the trash if
+// Retention is set, and otherwise purges it. Files whose key files predate
+// layouts are always purged.
+func (c *Client) RmContext(ctx context.Context, folder *Folder) error {
+	if c.Retention > 0 {
+		return c.TrashContext(ctx, folder)
+	}
+	return c.PurgeContext(ctx, folder)
+}
 
-	// Get IDs
-	keyID, err := folder.KeyFile.ID()
+// Upload ...
+func (c *Client) Upload(parent *Folder, meta core.Meta, data []byte) error {
+	return c.UploadContext(context.Background(), parent, meta, data)
+}
+
+// UploadContext ...
+func (c *Client) UploadContext(ctx context.Context, parent *Folder, meta core.Meta, data []byte) error {
+	return c.UploadFromContext(ctx, parent, meta, bytes.NewReader(data), int64(len(data)))
+}
+
+// UploadFrom streams length bytes from r into a new file, uploading each
+// block as it is encrypted and the key file once all blocks are stored
+func (c *Client) UploadFrom(parent *Folder, meta core.Meta, r io.Reader, length int64) error {
+	return c.UploadFromContext(context.Background(), parent, meta, r, length)
+}
+
+// UploadFromContext ...
+func (c *Client) UploadFromContext(ctx context.Context, parent *Folder, meta core.Meta, r io.Reader, length int64) error {
+	return c.UploadWithOptions(ctx, parent, meta, r, length, c.Options)
+}
+
+// UploadWithOptions streams length bytes from r into a new file stored with
+// options instead of the client's default options
+func (c *Client) UploadWithOptions(ctx context.Context, parent *Folder, meta core.Meta, r io.Reader, length int64, options core.Options) error {
+	meta.Type = "file"
+	meta = c.describe(meta)
+	index, version, err := c.nextIndex(ctx, parent)
 	if err != nil {
 		return err
 	}
 
-	var metaBlockIds []string
-	var fileBlockIds []string
+	return c.createFile(ctx, parent, index, version, meta, r, length, options, nil)
+}
+
+// createFile uploads length bytes from r as the file at index in parent,
+// replacing previous as its next version if it is not nil
+func (c *Client) createFile(ctx context.Context, parent *Folder, index uint32, version uint32, meta core.Meta, r io.Reader, length int64, options core.Options, previous *core.KeyFile) error {
+	if options.Chunking == core.ChunkingContent {
+		return c.uploadChunked(ctx, parent, index, version, meta, r, length, options, previous)
+	}
+
+	p := newPool(ctx, c.Workers)
+	file, err := core.CreateFileFromReader(parent.Key, index, meta, r, length, c.Size, version, options, func(block core.EncryptedBlock) error {
+		return p.Go(block.ID, func(ctx context.Context) error {
+			return c.handlers.Upload(ctx, block.ID, block.Data)
+		})
+	})
+
+	// Wait for In Flight Blocks
+	if poolErr := p.Wait(); poolErr != nil {
+		err = poolErr
+	}
 
-	file, err := c.getFileDetails(folder.Parent, folder.Index)
 	if err != nil {
-		return err
+		return cleanUp(err, func(ctx context.Context) error {
+			return c.deleteBlocks(ctx, p.Done())
+		})
 	}
 
-	metaID := core.FileID(file.PublicKey, file.KeyFile.MetaSalt)
-	metaBlockIds, err = c.getBlockIds(file.KeyFile.Key(), metaID)
+	dropped, err := c.uploadRevision(ctx, file, previous, p.Done())
 	if err != nil {
 		return err
 	}
 
-	if file.Meta.Type == "file" {
-		fileID := core.FileID(file.PublicKey, file.KeyFile.FileSalt)
-		fileBlockIds, err = c.getBlockIds(file.KeyFile.Key(), fileID)
-		if err != nil {
-			return err
-		}
-	}
+	return c.freeRevisions(ctx, previous, dropped)
+}
 
-	// Delete Files
-	err = c.handlers.Delete(keyID)
+// uploadChunked uploads the chunks of a new file that are not already
+// stored, records the file as a user of each chunk and uploads the file
+func (c *Client) uploadChunked(ctx context.Context, parent *Folder, index uint32, version uint32, meta core.Meta, r io.Reader, length int64, options core.Options, previous *core.KeyFile) error {
+	keys, err := c.getChunkKeys()
 	if err != nil {
 		return err
 	}
 
-	for _, id := range metaBlockIds {
-		err := c.handlers.Delete(id)
-		if err != nil {
-			return err
+	p := newPool(ctx, c.Workers)
+	queued := map[string]bool{}
+	var mutex sync.Mutex
+	uploaded := []string{}
+	file, manifest, err := core.CreateChunkedFile(parent.Key, index, meta, io.LimitReader(r, length), c.Size, version, options, keys, func(ref core.ChunkRef, block core.EncryptedBlock) error {
+		if queued[ref.ID] {
+			return nil
 		}
+		queued[ref.ID] = true
+
+		return p.Go(block.ID, func(ctx context.Context) error {
+			stored, err := c.uploadChunk(ctx, block)
+			if stored {
+				mutex.Lock()
+				uploaded = append(uploaded, block.ID)
+				mutex.Unlock()
+			}
+			return err
+		})
+	})
+
+	// Wait for In Flight Chunks
+	if poolErr := p.Wait(); poolErr != nil {
+		err = poolErr
 	}
 
-	for _, id := range fileBlockIds {
-		err := c.handlers.Delete(id)
-		if err != nil {
-			return err
-		}
+	if err == nil && manifest.Size() != length {
+		err = io.ErrUnexpectedEOF
 	}
 
-	// Create New Files
-	version, err := file.KeyFile.GetVersion()
+	// Delete the Chunks Uploaded, as Nothing Records Them Yet
 	if err != nil {
-		return err
+		return cleanUp(err, func(ctx context.Context) error {
+			return c.deleteBlocks(ctx, uploaded)
+		})
 	}
 
-	newFile, err := core.CreateFile(file.Parent.Key, folder.Index, core.Meta{}, []byte{}, c.Size, version+1)
+	publicKey, err := file.KeyFile.PublicKey()
 	if err != nil {
-		return err
+		return cleanUp(err, func(ctx context.Context) error {
+			return c.deleteBlocks(ctx, uploaded)
+		})
+	}
+
+	// Release the Chunks if the File is not Uploaded, Deleting Only New Ones
+	owner := core.FileID(publicKey, file.KeyFile.FileSalt)
+	created := map[string]bool{}
+	for _, id := range uploaded {
+		created[id] = true
+	}
+	var dropped []core.Revision
+	err = c.addChunkRefs(ctx, keys, owner, manifest.ChunkIDs())
+	if err == nil {
+		dropped, err = c.uploadRevision(ctx, file, previous, nil)
 	}
 
-	err = c.uploadFile(newFile)
 	if err != nil {
-		return err
+		return cleanUp(err, func(ctx context.Context) error {
+			return c.releaseChunks(ctx, keys, owner, manifest.ChunkIDs(), created)
+		})
 	}
 
-	c.Refresh(folder.Parent)
+	return c.freeRevisions(ctx, previous, dropped)
+}
 
-	return nil
+// cleanUp undoes part of a failed change with fn, even if the context of the
+// change was cancelled, and returns err along with any failure of fn
+func cleanUp(err error, fn func(ctx context.Context) error) error {
+	cleanupErr := fn(context.Background())
+	if cleanupErr != nil {
+		return fmt.Errorf("%w (cleaning up failed: %v)", err, cleanupErr)
+	}
+	return err
 }
 
-// Upload ...
-func (c *Client) Upload(parent *Folder, meta core.Meta, data []byte) error {
-	meta.Type = "file"
-	count, err := c.getChildCount(parent)
+// Download ...
+func (c *Client) Download(folder *Folder, index uint32) ([]byte, error) {
+	return c.DownloadContext(context.Background(), folder, index)
+}
+
+// DownloadContext ...
+func (c *Client) DownloadContext(ctx context.Context, folder *Folder, index uint32) ([]byte, error) {
+	var buffer bytes.Buffer
+	_, err := c.DownloadToContext(ctx, folder, index, &buffer)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	index := count + 1
+	return buffer.Bytes(), nil
+}
 
-	file, err := core.CreateFile(parent.Key, index, meta, data, c.Size, 0)
+// DownloadTo decrypts the file blocks in order into w, holding at most one
+// block per worker in memory at a time
+func (c *Client) DownloadTo(folder *Folder, index uint32, w io.Writer) (int64, error) {
+	return c.DownloadToContext(context.Background(), folder, index, w)
+}
+
+// DownloadToContext ...
+func (c *Client) DownloadToContext(ctx context.Context, folder *Folder, index uint32, w io.Writer) (int64, error) {
+	keyFile, err := c.getKeyFile(ctx, folder, index)
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	if keyFile == nil {
+		return 0, ErrNotExist
 	}
 
-	err = c.uploadFile(file)
+	set, err := fileBlockSet(keyFile)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return nil
-}
 
-// Download ...
-func (c *Client) Download(folder *Folder, index uint32) ([]byte, error) {
-	keyFile, err := c.getKeyFile(folder, index)
+	err = c.loadTree(ctx, &set)
+	if err != nil {
+		return 0, err
+	}
 
-	publicKey, err := keyFile.PublicKey()
+	layout, err := keyFile.GetLayout()
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	// Recreate File
-	fileID := core.FileID(publicKey, keyFile.FileSalt)
-	fileBlocks, err := c.getBlocks(keyFile.Key(), fileID)
+	if layout.Chunking == core.ChunkingContent {
+		manifest, err := c.getManifest(ctx, set)
+		if err != nil {
+			return 0, err
+		}
+		return c.writeChunks(ctx, manifest, w)
+	}
+
+	if layout.Compression == core.CompressionNone {
+		return c.writeBlocks(ctx, set, w)
+	}
+
+	// Decompress Blocks as They Are Written
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := c.writeBlocks(ctx, set, pw)
+		pw.CloseWithError(err)
+	}()
+	defer pr.Close()
+
+	data, err := core.Decompress(pr, layout.Compression)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	fileData := core.RecreateFile(fileBlocks)
+	defer data.Close()
 
-	return fileData, nil
+	return io.Copy(w, data)
+}
+
+// writeBlocks writes the decrypted blocks of set in order into w
+func (c *Client) writeBlocks(ctx context.Context, set blockSet, w io.Writer) (int64, error) {
+	block0, err := c.resolveCount(ctx, &set)
+	if err != nil {
+		return 0, err
+	}
+
+	var written int64
+	var blocks []core.Block
+	next := 0
+	if block0 != nil {
+		blocks = []core.Block{*block0}
+		next = 1
+	}
+
+	for {
+		for _, block := range blocks {
+			n, err := w.Write(block.Data)
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+		}
+
+		if next >= set.count {
+			break
+		}
+
+		// Fetch Next Window of Blocks
+		end := next + c.Workers
+		if c.Workers < 1 || end > set.count {
+			end = set.count
+		}
+
+		blocks, err = c.getBlockRange(ctx, set, next, end)
+		if err != nil {
+			return written, err
+		}
+		next = end
+	}
+
+	return written, nil
 }
 
 // Find ...
 func (c *Client) Find(folder *Folder, query string, ret []Folder) ([]Folder, error) {
+	return c.FindContext(context.Background(), folder, query, ret)
+}
+
+// FindContext ...
+func (c *Client) FindContext(ctx context.Context, folder *Folder, query string, ret []Folder) ([]Folder, error) {
 	if ret == nil {
 		ret = make([]Folder, 0, 0)
 	}
 
-	count, err := c.getChildCount(folder)
+	count, err := c.getChildCount(ctx, folder)
 	if err != nil {
 		return ret, err
 	}
@@ -572,9 +1254,13 @@ func (c *Client) Find(folder *Folder, query string, ret []Folder) ([]Folder, err
 		return ret, nil
 	}
 
-	children := c.Ls(folder)
+	children, err := c.LsContext(ctx, folder)
+	if err != nil {
+		return ret, err
+	}
+
 	for _, child := range children {
-		results, err := c.Find(&child, query, nil)
+		results, err := c.FindContext(ctx, &child, query, nil)
 		if err != nil {
 			return ret, err
 		}
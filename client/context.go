@@ -0,0 +1,54 @@
+package client
+
+import "context"
+
+// ContextHandlers Abstract Interface with cancellation and deadlines
+type ContextHandlers interface {
+	Upload(ctx context.Context, id string, data []byte) error
+	Download(ctx context.Context, id string) ([]byte, error)
+	Delete(ctx context.Context, id string) error
+	Exists(ctx context.Context, id string) (bool, error)
+}
+
+// handlersAdapter adapts Handlers to ContextHandlers
+type handlersAdapter struct {
+	handlers Handlers
+}
+
+// WithContext returns ContextHandlers for handlers without context support,
+// checking for cancellation before each call
+func WithContext(handlers Handlers) ContextHandlers {
+	return handlersAdapter{handlers: handlers}
+}
+
+// Upload ...
+func (h handlersAdapter) Upload(ctx context.Context, id string, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return h.handlers.Upload(id, data)
+}
+
+// Download ...
+func (h handlersAdapter) Download(ctx context.Context, id string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return h.handlers.Download(id)
+}
+
+// Delete ...
+func (h handlersAdapter) Delete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return h.handlers.Delete(id)
+}
+
+// Exists ...
+func (h handlersAdapter) Exists(ctx context.Context, id string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return h.handlers.Exists(id), nil
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"context"
+	"fmt"
+)
+
+// CopyProgress reports how much of a copy is done. Totals are counted before
+// copying from the recorded sizes, which files uploaded before sizes were
+// recorded do not have.
+type CopyProgress struct {
+	Path         string
+	Entries      int
+	Bytes        int64
+	TotalEntries int
+	TotalBytes   int64
+}
+
+// Copy re-encrypts a file, or a folder and everything in it, as the next
+// child of parent, keeping its metadata, and returns the copy
+func (c *Client) Copy(folder *Folder, parent *Folder) (*Folder, error) {
+	return c.CopyContext(context.Background(), folder, parent, nil)
+}
+
+// CopyContext is Copy calling progress after each file or folder is copied.
+// If the copy fails, everything copied so far is removed.
+func (c *Client) CopyContext(ctx context.Context, folder *Folder, parent *Folder, progress func(CopyProgress)) (*Folder, error) {
+	err := checkNotInside(folder, parent)
+	if err != nil {
+		return nil, err
+	}
+
+	var state CopyProgress
+	var copied func(*Folder, int64)
+	if progress != nil {
+		state.TotalEntries, state.TotalBytes, err = c.treeSize(ctx, folder)
+		if err != nil {
+			return nil, err
+		}
+
+		copied = func(f *Folder, size int64) {
+			state.Path = f.Path
+			state.Entries++
+			state.Bytes += size
+			progress(state)
+		}
+	}
+
+	return c.copyNext(ctx, folder, parent, copied)
+}
+
+// copyNext copies folder to the first free index in parent, removing the
+// partial copy if it fails
+func (c *Client) copyNext(ctx context.Context, folder *Folder, parent *Folder, copied func(*Folder, int64)) (*Folder, error) {
+	index, version, err := c.nextIndex(ctx, parent)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := c.copyTree(ctx, folder, parent, index, version, copied)
+	if err != nil {
+		return nil, cleanUp(err, func(ctx context.Context) error {
+			partial, err := c.getFileDetails(ctx, parent, index)
+			if err != nil || partial == nil {
+				return err
+			}
+			return c.PurgeContext(ctx, partial)
+		})
+	}
+
+	return target, nil
+}
+
+// checkNotInside returns an error if parent is folder or inside it
+func checkNotInside(folder *Folder, parent *Folder) error {
+	for f := parent; ; f = f.Parent {
+		if f.Key.String() == folder.Key.String() {
+			return fmt.Errorf("Cannot copy or move %s into itself", folder.Path)
+		}
+		if f.Parent == f {
+			return nil
+		}
+	}
+}
+
+// treeSize returns the number of files and folders in a tree, including its
+// root, and the recorded size of its files
+func (c *Client) treeSize(ctx context.Context, folder *Folder) (int, int64, error) {
+	if folder.Meta.Type != "folder" {
+		return 1, folder.Meta.Size, nil
+	}
+
+	count, err := c.getChildCount(ctx, folder)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	entries, size := 1, int64(0)
+	for i := uint32(1); i <= count; i++ {
+		child, err := c.getFileDetails(ctx, folder, i)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		if child == nil {
+			continue
+		}
+
+		n, s, err := c.treeSize(ctx, child)
+		if err != nil {
+			return 0, 0, err
+		}
+		entries += n
+		size += s
+	}
+
+	return entries, size, nil
+}
@@ -9,6 +9,12 @@ import (
 
 // NewClient returns a new client form mnemonic and password
 func NewClient(mnemonic string, password string, size int, handlers Handlers) (*Client, error) {
+	return NewContextClient(mnemonic, password, size, WithContext(handlers))
+}
+
+// NewContextClient returns a new client form mnemonic and password for
+// handlers with context support
+func NewContextClient(mnemonic string, password string, size int, handlers ContextHandlers) (*Client, error) {
 	var err error
 	var masterKey *hdkeychain.ExtendedKey
 
@@ -22,39 +28,29 @@ func NewClient(mnemonic string, password string, size int, handlers Handlers) (*
 		return nil, err
 	}
 
-	root := Folder{
-		File: File{
-			Index: 0,
-			Path:  "/",
-			Meta: &core.Meta{
-				Name: "root",
-				Type: "folder",
-			},
-		},
-		Key:      masterKey,
-		Children: map[uint32]Folder{},
-	}
-
-	root.Parent = &root
+	client := newClient(masterKey, size, handlers)
+	client.Mnemonic = mnemonic
 
-	return &Client{
-		Mnemonic:  mnemonic,
-		masterKey: masterKey,
-		handlers:  handlers,
-		pwd:       &root,
-		root:      &root,
-		Size:      size,
-		mutex:     &sync.Mutex{},
-	}, nil
+	return client, nil
 }
 
 // NewClientFromKey returns a new client from an extended key string
 func NewClientFromKey(key string, size int, handlers Handlers) (*Client, error) {
+	return NewContextClientFromKey(key, size, WithContext(handlers))
+}
+
+// NewContextClientFromKey returns a new client from an extended key string
+// for handlers with context support
+func NewContextClientFromKey(key string, size int, handlers ContextHandlers) (*Client, error) {
 	masterKey, err := core.GetRootFolderFromKey(key)
 	if err != nil {
 		return nil, err
 	}
 
+	return newClient(masterKey, size, handlers), nil
+}
+
+func newClient(masterKey *hdkeychain.ExtendedKey, size int, handlers ContextHandlers) *Client {
 	root := Folder{
 		File: File{
 			Index: 0,
@@ -76,6 +72,8 @@ func NewClientFromKey(key string, size int, handlers Handlers) (*Client, error)
 		root:      &root,
 		pwd:       &root,
 		Size:      size,
+		Workers:   DefaultWorkers,
+		Revisions: DefaultRevisions,
 		mutex:     &sync.Mutex{},
-	}, nil
+	}
 }
@@ -0,0 +1,221 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/beritani/whitebox/core"
+)
+
+// Keys derive from the parent folder's key and the index, so renaming only
+// replaces the meta blocks, while moving re-encrypts a file, and everything
+// in a folder, under its new parent.
+
+// ErrRoot is returned when the root folder would be renamed or moved
+var ErrRoot = errors.New("Cannot rename or move the root folder")
+
+// Rename changes the name of a file or folder, keeping its file blocks
+func (c *Client) Rename(folder *Folder, name string) error {
+	return c.RenameContext(context.Background(), folder, name)
+}
+
+// RenameContext ...
+func (c *Client) RenameContext(ctx context.Context, folder *Folder, name string) error {
+	if folder.Parent == folder {
+		return ErrRoot
+	}
+
+	file, err := c.getFileDetails(ctx, folder.Parent, folder.Index)
+	if err != nil {
+		return err
+	}
+
+	if file == nil {
+		return ErrNotExist
+	}
+
+	metaSet, err := metaBlockSet(file.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	metaBlockIds, err := c.getBlockIds(ctx, metaSet)
+	if err != nil {
+		return err
+	}
+
+	layout, err := file.KeyFile.GetLayout()
+	if err != nil {
+		return err
+	}
+
+	// Replace Key File and Meta Blocks, Signed by the Next Owner
+	meta := *file.Meta
+	meta.Name = name
+	revised, err := core.ReviseFile(*file.KeyFile, meta, c.Size, storageOptions(layout, c.Options))
+	if err != nil {
+		return err
+	}
+
+	err = c.uploadFile(ctx, revised)
+	if err != nil {
+		return err
+	}
+
+	file.KeyFile = &revised.KeyFile
+	file.Meta = &meta
+	folder.Parent.Children[folder.Index] = *file
+	folder.KeyFile, folder.Meta = file.KeyFile, file.Meta
+
+	return c.deleteBlocks(ctx, metaBlockIds)
+}
+
+// Move re-encrypts a file or folder as the next child of parent and removes
+// the original, returning the moved file. A failed copy is removed, but if
+// removing the original fails the copy is kept and returned with the error.
+func (c *Client) Move(folder *Folder, parent *Folder) (*Folder, error) {
+	return c.MoveContext(context.Background(), folder, parent)
+}
+
+// MoveContext ...
+func (c *Client) MoveContext(ctx context.Context, folder *Folder, parent *Folder) (*Folder, error) {
+	if folder.Parent == folder {
+		return nil, ErrRoot
+	}
+
+	err := checkNotInside(folder, parent)
+	if err != nil {
+		return nil, err
+	}
+
+	moved, err := c.copyNext(ctx, folder, parent, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// The Original may be Partly Removed, so the Copy is Kept
+	err = c.PurgeContext(ctx, folder)
+	if err != nil {
+		return moved, fmt.Errorf("Copied to %s, but removing %s failed: %w", moved.Path, folder.Path, err)
+	}
+
+	return moved, nil
+}
+
+// copyTree re-encrypts src, and the files in it if it is a folder, as the
+// file at index in parent with a key file of version, keeping its metadata
+// and storage options. Files in folders are copied to the first free
+// indices. copied is called, if it is not nil, with each copy and the size
+// of its data.
+func (c *Client) copyTree(ctx context.Context, src *Folder, parent *Folder, index uint32, version uint32, copied func(*Folder, int64)) (*Folder, error) {
+	layout, err := src.KeyFile.GetLayout()
+	if err != nil {
+		return nil, err
+	}
+	options := storageOptions(layout, c.Options)
+
+	if src.Meta.Type != "folder" {
+		reader, err := c.OpenContext(ctx, src.Parent, src.Index)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+
+		err = c.createFile(ctx, parent, index, version, *src.Meta, reader, reader.Size(), options, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		file, err := c.getFileDetails(ctx, parent, index)
+		if err == nil && file != nil && copied != nil {
+			copied(file, reader.Size())
+		}
+		return file, err
+	}
+
+	file, err := core.CreateFolderWithOptions(parent.Key, index, *src.Meta, c.Size, version, options)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := c.storeFile(ctx, file)
+	if err != nil {
+		return nil, cleanUp(err, func(ctx context.Context) error {
+			return c.deleteBlocks(ctx, stored)
+		})
+	}
+
+	folder, err := c.getFileDetails(ctx, parent, index)
+	if err != nil {
+		return nil, err
+	}
+
+	if folder == nil {
+		return nil, ErrNotExist
+	}
+
+	if copied != nil {
+		copied(folder, 0)
+	}
+
+	count, err := c.getChildCount(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+
+	next := uint32(1)
+	for i := uint32(1); i <= count; i++ {
+		child, err := c.getFileDetails(ctx, src, i)
+		if err != nil {
+			return nil, err
+		}
+
+		if child == nil {
+			continue
+		}
+
+		// Find a Free Index, as Removed Files May Remain in a Reused One
+		var childVersion uint32
+		for free := false; !free; next++ {
+			childVersion, free, err = c.slotVersion(ctx, folder, next)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		_, err = c.copyTree(ctx, child, folder, next-1, childVersion, copied)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return folder, nil
+}
+
+// storageOptions returns the options a file was stored with, for those the
+// layout records
+func storageOptions(layout core.Layout, options core.Options) core.Options {
+	options.Compression = layout.Compression
+	options.Chunking = layout.Chunking
+	options.DataShards = layout.DataShards
+	options.ParityShards = layout.ParityShards
+	options.Padding = layout.Padding
+	return options
+}
+
+// deleteBlocks deletes blocks by id
+func (c *Client) deleteBlocks(ctx context.Context, ids []string) error {
+	p := newPool(ctx, c.Workers)
+	for _, id := range ids {
+		id := id
+		err := p.Go(id, func(ctx context.Context) error {
+			return c.handlers.Delete(ctx, id)
+		})
+		if err != nil {
+			break
+		}
+	}
+
+	return p.Wait()
+}
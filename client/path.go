@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Paths are made of names separated by slashes, such as /photos/2023/beach.jpg.
+// Each segment is resolved in order:
+//
+//   - "#N" is the child at index N
+//   - a segment of digits is the child at that index if there is one, so
+//     index paths such as /1/3/2 keep working without reading any metadata
+//   - otherwise a segment is the name of exactly one child, and names shared
+//     by several children are ambiguous, so one of them must be chosen by index
+//
+// Segments are percent-decoded after they are split, so names containing "/"
+// or "%", starting with "#", made only of digits or named "." or ".." are
+// written with EscapeName.
+
+// ErrAmbiguous is returned when a path names more than one file
+var ErrAmbiguous = errors.New("Path is ambiguous")
+
+// EscapeName returns name as a path segment that resolves to it
+func EscapeName(name string) string {
+	switch name {
+	case ".":
+		return "%2E"
+	case "..":
+		return "%2E%2E"
+	}
+
+	name = strings.ReplaceAll(name, "%", "%25")
+	name = strings.ReplaceAll(name, "/", "%2F")
+	if strings.HasPrefix(name, "#") {
+		name = "%23" + name[1:]
+	}
+	if isDigits(name) {
+		name = fmt.Sprintf("%%%X", name[0]) + name[1:]
+	}
+	return name
+}
+
+// isDigits returns true for segments made only of digits
+func isDigits(segment string) bool {
+	if segment == "" {
+		return false
+	}
+
+	for _, r := range segment {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveSegment returns the index of the child of folder a path segment
+// refers to
+func (c *Client) resolveSegment(ctx context.Context, folder *Folder, segment string) (uint32, error) {
+	if strings.HasPrefix(segment, "#") {
+		index, err := strconv.ParseUint(segment[1:], 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid index %q", segment)
+		}
+		return uint32(index), nil
+	}
+
+	// Index Paths Need No Metadata
+	if isDigits(segment) {
+		index, err := strconv.ParseUint(segment, 10, 32)
+		if err == nil {
+			child, err := c.getFileDetails(ctx, folder, uint32(index))
+			if err != nil {
+				return 0, err
+			}
+			if child != nil {
+				return uint32(index), nil
+			}
+		}
+	}
+
+	name, err := url.PathUnescape(segment)
+	if err != nil {
+		return 0, err
+	}
+
+	// Removed Files are Skipped
+	count, err := c.getChildCount(ctx, folder)
+	if err != nil {
+		return 0, err
+	}
+
+	// Files that Cannot be Read are Reported if No Other Matches
+	matches := []uint32{}
+	failed := map[uint32]error{}
+	for index := uint32(1); index <= count; index++ {
+		child, err := c.getFileDetails(ctx, folder, index)
+		if err != nil {
+			failed[index] = err
+			continue
+		}
+		if child != nil && child.Meta.Name == name {
+			matches = append(matches, index)
+		}
+	}
+
+	switch {
+	case len(matches) == 1:
+		return matches[0], nil
+	case len(matches) > 1:
+		return 0, fmt.Errorf("%w: %q is the name of #%v", ErrAmbiguous, name, joinIndices(matches))
+	case ctx.Err() != nil:
+		return 0, ctx.Err()
+	case len(failed) > 0:
+		return 0, &ListError{Path: folder.Path, Errs: failed}
+	}
+
+	return 0, fmt.Errorf("%w: %q", ErrNotExist, name)
+}
+
+func joinIndices(indices []uint32) string {
+	s := make([]string, len(indices))
+	for i, index := range indices {
+		s[i] = strconv.FormatUint(uint64(index), 10)
+	}
+	return strings.Join(s, ", #")
+}
@@ -0,0 +1,116 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// DefaultWorkers is the number of concurrent block transfers of a new client
+const DefaultWorkers = 4
+
+// BlockError reports the block a transfer failed on
+type BlockError struct {
+	ID  string
+	Err error
+}
+
+func (e *BlockError) Error() string {
+	return fmt.Sprintf("Block %s: %v", e.ID, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *BlockError) Unwrap() error {
+	return e.Err
+}
+
+// pool runs block transfers on a bounded number of goroutines, stops
+// accepting work after the first failure and cancels transfers in flight
+type pool struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	sem    chan struct{}
+	wg     sync.WaitGroup
+	mutex  sync.Mutex
+	err    error
+	done   []string
+}
+
+func newPool(ctx context.Context, workers int) *pool {
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &pool{
+		ctx:    ctx,
+		cancel: cancel,
+		sem:    make(chan struct{}, workers),
+	}
+}
+
+func (p *pool) fail(err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.err == nil {
+		p.err = err
+		p.cancel()
+	}
+}
+
+// Err returns the first failure
+func (p *pool) Err() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.err
+}
+
+// Go runs fn for the block id once a worker is free
+func (p *pool) Go(id string, fn func(ctx context.Context) error) error {
+	if err := p.Err(); err != nil {
+		return err
+	}
+
+	select {
+	case p.sem <- struct{}{}:
+	case <-p.ctx.Done():
+		p.fail(p.ctx.Err())
+		return p.Err()
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer func() {
+			<-p.sem
+			p.wg.Done()
+		}()
+
+		if p.Err() != nil {
+			return
+		}
+
+		if err := fn(p.ctx); err != nil {
+			p.fail(&BlockError{ID: id, Err: err})
+			return
+		}
+
+		p.mutex.Lock()
+		p.done = append(p.done, id)
+		p.mutex.Unlock()
+	}()
+
+	return nil
+}
+
+// Done returns the blocks whose transfers succeeded
+func (p *pool) Done() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]string{}, p.done...)
+}
+
+// Wait blocks until all transfers are done and returns the first failure
+func (p *pool) Wait() error {
+	p.wg.Wait()
+	p.cancel()
+	return p.Err()
+}
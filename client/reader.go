@@ -0,0 +1,346 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/beritani/whitebox/core"
+)
+
+// FileReader reads the plaintext of an encrypted file, fetching and
+// decrypting only the blocks or chunks that cover the requested byte range.
+// Compressed files are decompressed from the start of the file, so reading
+// them backwards starts decompressing again.
+type FileReader struct {
+	ctx         context.Context
+	client      *Client
+	set         blockSet
+	blockSize   int64
+	stored      int64
+	size        int64
+	offset      int64
+	mutex       *sync.Mutex
+	cached      int
+	block       []byte
+	compression string
+	stream      io.ReadCloser
+	position    int64
+	chunks      []core.ChunkRef
+	offsets     []int64
+}
+
+// storedReader reads the stored data of a file before it is decompressed
+type storedReader struct {
+	*FileReader
+}
+
+func (r storedReader) ReadAt(p []byte, off int64) (int, error) {
+	return r.readStoredAt(p, off)
+}
+
+// Open returns a reader over the file at index in folder
+func (c *Client) Open(folder *Folder, index uint32) (*FileReader, error) {
+	return c.OpenContext(context.Background(), folder, index)
+}
+
+// OpenContext returns a reader over the file at index in folder which
+// fetches blocks with ctx
+func (c *Client) OpenContext(ctx context.Context, folder *Folder, index uint32) (*FileReader, error) {
+	keyFile, err := c.getKeyFile(ctx, folder, index)
+	if err != nil {
+		return nil, err
+	}
+
+	if keyFile == nil {
+		return nil, ErrNotExist
+	}
+
+	return c.openKeyFile(ctx, keyFile)
+}
+
+// openKeyFile returns a reader over the file blocks of a key file
+func (c *Client) openKeyFile(ctx context.Context, keyFile *core.KeyFile) (*FileReader, error) {
+	set, err := fileBlockSet(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.loadTree(ctx, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	layout, err := keyFile.GetLayout()
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &FileReader{
+		ctx:         ctx,
+		client:      c,
+		mutex:       &sync.Mutex{},
+		cached:      -1,
+		compression: layout.Compression,
+	}
+
+	if layout.Chunking == core.ChunkingContent {
+		manifest, err := c.getManifest(ctx, set)
+		if err != nil {
+			return nil, err
+		}
+
+		// Chunk Offsets
+		reader.chunks = manifest.Chunks
+		reader.offsets = make([]int64, len(manifest.Chunks))
+		for i, chunk := range manifest.Chunks {
+			reader.offsets[i] = reader.size
+			reader.size += int64(chunk.Size)
+		}
+
+		return reader, nil
+	}
+
+	// Block Size from First Block, Padding Blocks Have No Data
+	block0, err := c.resolveCount(ctx, &set)
+	if err != nil {
+		return nil, err
+	}
+	reader.set = set
+
+	if set.count == 0 {
+		return reader, nil
+	}
+
+	if block0 == nil {
+		block, err := c.getBlock(ctx, set, 0)
+		if err != nil {
+			return nil, err
+		}
+		block0 = &block
+	}
+	reader.blockSize = int64(len(block0.Data) + block0.Padding)
+	reader.cached = 0
+	reader.block = block0.Data
+
+	// File Size from Last Block
+	if layout.Padding != core.PaddingNone {
+		reader.stored = layout.Stored
+	} else {
+		last := *block0
+		if set.count > 1 {
+			last, err = c.getBlock(ctx, set, set.count-1)
+			if err != nil {
+				return nil, err
+			}
+			reader.cached = set.count - 1
+			reader.block = last.Data
+		}
+		reader.stored = int64(set.count-1)*reader.blockSize + int64(len(last.Data))
+	}
+
+	reader.size = reader.stored
+	if reader.compression != core.CompressionNone {
+		reader.size = layout.Size
+	}
+
+	return reader, nil
+}
+
+// Size returns the plaintext size of the file
+func (r *FileReader) Size() int64 {
+	return r.size
+}
+
+func (r *FileReader) getBlock(index int) ([]byte, error) {
+	if index == r.cached {
+		return r.block, nil
+	}
+
+	block, err := r.client.getBlock(r.ctx, r.set, index)
+	if err != nil {
+		return nil, err
+	}
+
+	r.cached = index
+	r.block = block.Data
+
+	return r.block, nil
+}
+
+// ReadAt implements io.ReaderAt
+func (r *FileReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("FileReader.ReadAt: negative offset")
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.offsets != nil {
+		return r.readChunkedAt(p, off)
+	}
+
+	if r.compression != core.CompressionNone {
+		return r.readCompressedAt(p, off)
+	}
+
+	return r.readStoredAt(p, off)
+}
+
+func (r *FileReader) readStoredAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		if off >= r.stored {
+			return n, io.EOF
+		}
+
+		data, err := r.getBlock(int(off / r.blockSize))
+		if err != nil {
+			return n, err
+		}
+
+		// Short Blocks Would Otherwise Never Reach the End
+		if int64(len(data)) <= off%r.blockSize {
+			return n, io.ErrUnexpectedEOF
+		}
+
+		copied := copy(p[n:], data[off%r.blockSize:])
+		n += copied
+		off += int64(copied)
+	}
+
+	return n, nil
+}
+
+func (r *FileReader) getChunk(index int) ([]byte, error) {
+	if index == r.cached {
+		return r.block, nil
+	}
+
+	data, err := r.client.getChunk(r.ctx, r.chunks[index])
+	if err != nil {
+		return nil, err
+	}
+
+	r.cached = index
+	r.block = data
+
+	return r.block, nil
+}
+
+func (r *FileReader) readChunkedAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		if off >= r.size {
+			return n, io.EOF
+		}
+
+		index := sort.Search(len(r.offsets), func(i int) bool {
+			return r.offsets[i] > off
+		}) - 1
+
+		data, err := r.getChunk(index)
+		if err != nil {
+			return n, err
+		}
+
+		if int64(len(data)) <= off-r.offsets[index] {
+			return n, io.ErrUnexpectedEOF
+		}
+
+		copied := copy(p[n:], data[off-r.offsets[index]:])
+		n += copied
+		off += int64(copied)
+	}
+
+	return n, nil
+}
+
+func (r *FileReader) readCompressedAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+
+	// Restart Decompression to Read Backwards
+	if r.stream == nil || off < r.position {
+		r.closeStream()
+		stream, err := core.Decompress(io.NewSectionReader(storedReader{r}, 0, r.stored), r.compression)
+		if err != nil {
+			return 0, err
+		}
+		r.stream = stream
+		r.position = 0
+	}
+
+	skipped, err := io.CopyN(ioutil.Discard, r.stream, off-r.position)
+	r.position += skipped
+	if err != nil {
+		r.closeStream()
+		return 0, err
+	}
+
+	n, err := io.ReadFull(r.stream, p)
+	r.position += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	if err != nil && err != io.EOF {
+		r.closeStream()
+	}
+
+	return n, err
+}
+
+// closeStream closes the decompression stream, if there is one
+func (r *FileReader) closeStream() error {
+	if r.stream == nil {
+		return nil
+	}
+
+	err := r.stream.Close()
+	r.stream = nil
+	return err
+}
+
+// Close implements io.Closer, closing the decompression stream of a
+// compressed file
+func (r *FileReader) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.closeStream()
+}
+
+// Read implements io.Reader
+func (r *FileReader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.offset)
+	r.offset += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker
+func (r *FileReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("FileReader.Seek: invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("FileReader.Seek: negative position")
+	}
+
+	r.offset = offset
+	return offset, nil
+}
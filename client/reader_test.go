@@ -0,0 +1,41 @@
+package client
+
+import (
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestReadStoredAtShortBlock(t *testing.T) {
+	for _, data := range [][]byte{make([]byte, 2), make([]byte, 4)} {
+		// A Block Shorter than its Offset, or Ending at it, Before the End
+		r := &FileReader{
+			mutex:     &sync.Mutex{},
+			blockSize: 8,
+			stored:    16,
+			size:      16,
+			cached:    0,
+			block:     data,
+		}
+
+		_, err := r.ReadAt(make([]byte, 4), 4)
+		if err != io.ErrUnexpectedEOF {
+			t.Fatalf("ReadAt with a %d byte block = %v, expected %v", len(data), err, io.ErrUnexpectedEOF)
+		}
+	}
+}
+
+func TestReadChunkedAtShortChunk(t *testing.T) {
+	r := &FileReader{
+		mutex:   &sync.Mutex{},
+		size:    16,
+		offsets: []int64{0, 8},
+		cached:  0,
+		block:   make([]byte, 3),
+	}
+
+	_, err := r.ReadAt(make([]byte, 4), 4)
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("ReadAt with a short chunk = %v, expected %v", err, io.ErrUnexpectedEOF)
+	}
+}
@@ -0,0 +1,196 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/beritani/whitebox/core"
+)
+
+// groups returns the number of erasure coded groups of blocks in the set
+func (s blockSet) groups() int {
+	if s.parity == 0 {
+		return 0
+	}
+	return (s.count + s.data - 1) / s.data
+}
+
+// shardID returns the id of the block or parity block at position i of a group
+func (s blockSet) shardID(group int, i int) string {
+	if i < s.data {
+		return core.BlockID(s.fileID, group*s.data+i)
+	}
+	return core.ParityID(s.fileID, group, i-s.data)
+}
+
+// getGroup downloads the blocks and parity blocks of a group, leaving the
+// missing and damaged ones nil. Positions past the end of a short group are
+// empty, which the erasure code treats as zeros.
+func (c *Client) getGroup(ctx context.Context, set blockSet, group int) ([][]byte, error) {
+	shards := make([][]byte, set.data+set.parity)
+	p := newPool(ctx, c.Workers)
+	for i := range shards {
+		i := i
+		index := group*set.data + i
+		if i < set.data && index >= set.count {
+			shards[i] = []byte{}
+			continue
+		}
+
+		err := p.Go(set.shardID(group, i), func(ctx context.Context) error {
+			data, err := c.handlers.Download(ctx, set.shardID(group, i))
+			if err != nil {
+				return ctx.Err()
+			}
+
+			if i < set.data {
+				if _, err := decryptBlock(set, index, data); err != nil {
+					return nil
+				}
+			}
+
+			shards[i] = data
+			return nil
+		})
+		if err != nil {
+			break
+		}
+	}
+
+	err := p.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	return shards, nil
+}
+
+// recoverBlock rebuilds a block from the rest of its group
+func (c *Client) recoverBlock(ctx context.Context, set blockSet, index int, cause error) (core.Block, error) {
+	erasure, err := core.NewErasure(set.data, set.parity)
+	if err != nil {
+		return core.Block{}, err
+	}
+
+	shards, err := c.getGroup(ctx, set, index/set.data)
+	if err != nil {
+		return core.Block{}, err
+	}
+
+	err = erasure.Reconstruct(shards)
+	if err != nil {
+		return core.Block{}, fmt.Errorf("%v, and cannot be rebuilt: %v", cause, err)
+	}
+
+	return decryptBlock(set, index, shards[index%set.data])
+}
+
+// Repair rebuilds and uploads the missing or damaged blocks and parity
+// blocks of a file, returning how many were uploaded
+func (c *Client) Repair(folder *Folder) (int, error) {
+	return c.RepairContext(context.Background(), folder)
+}
+
+// RepairContext ...
+func (c *Client) RepairContext(ctx context.Context, folder *Folder) (int, error) {
+	keyFile, err := c.getKeyFile(ctx, folder.Parent, folder.Index)
+	if err != nil {
+		return 0, err
+	}
+
+	if keyFile == nil {
+		return 0, ErrNotExist
+	}
+
+	metaSet, err := metaBlockSet(keyFile)
+	if err != nil {
+		return 0, err
+	}
+
+	fileSet, err := fileBlockSet(keyFile)
+	if err != nil {
+		return 0, err
+	}
+
+	// Repair Leaf Hashes before Checking File Blocks with Them
+	repaired := 0
+	for _, set := range []blockSet{metaSet, fileSet.treeSet()} {
+		n, err := c.repairSet(ctx, set)
+		repaired += n
+		if err != nil {
+			return repaired, err
+		}
+	}
+
+	err = c.loadTree(ctx, &fileSet)
+	if err != nil {
+		return repaired, err
+	}
+
+	n, err := c.repairSet(ctx, fileSet)
+	return repaired + n, err
+}
+
+func (c *Client) repairSet(ctx context.Context, set blockSet) (int, error) {
+	if set.parity == 0 {
+		return 0, nil
+	}
+
+	erasure, err := core.NewErasure(set.data, set.parity)
+	if err != nil {
+		return 0, err
+	}
+
+	repaired := 0
+	for group := 0; group < set.groups(); group++ {
+		shards, err := c.getGroup(ctx, set, group)
+		if err != nil {
+			return repaired, err
+		}
+
+		missing := []int{}
+		for i, shard := range shards {
+			if shard == nil {
+				missing = append(missing, i)
+			}
+		}
+
+		if len(missing) == 0 {
+			continue
+		}
+
+		err = erasure.Reconstruct(shards)
+		if err != nil {
+			return repaired, fmt.Errorf("Group %d of %s cannot be rebuilt: %v", group, set.fileID, err)
+		}
+
+		// Check Rebuilt Blocks before Uploading
+		for _, i := range missing {
+			if i < set.data {
+				_, err := decryptBlock(set, group*set.data+i, shards[i])
+				if err != nil {
+					return repaired, fmt.Errorf("Group %d of %s rebuilt incorrectly: %v", group, set.fileID, err)
+				}
+			}
+		}
+
+		p := newPool(ctx, c.Workers)
+		for _, i := range missing {
+			i := i
+			err := p.Go(set.shardID(group, i), func(ctx context.Context) error {
+				return c.handlers.Upload(ctx, set.shardID(group, i), shards[i])
+			})
+			if err != nil {
+				break
+			}
+		}
+
+		err = p.Wait()
+		if err != nil {
+			return repaired, err
+		}
+		repaired += len(missing)
+	}
+
+	return repaired, nil
+}
@@ -0,0 +1,270 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/beritani/whitebox/core"
+)
+
+// Updating a file replaces its key file with the next version, with new
+// salts and blocks, and keeps the blocks of up to Revisions versions before
+// it, which can be listed, read and rolled back to. The blocks of older
+// versions are deleted. Copies and moves start without revisions.
+
+// DefaultRevisions is the number of previous versions a new client keeps
+const DefaultRevisions = 5
+
+// ErrNotFile is returned when a folder would be updated
+var ErrNotFile = errors.New("Only files can be updated")
+
+// FileRevision is a version of a file
+type FileRevision struct {
+	Version uint32
+	Current bool
+	Meta    core.Meta
+}
+
+// Update replaces the data of a file, keeping its metadata
+func (c *Client) Update(folder *Folder, data []byte) error {
+	return c.UpdateFromContext(context.Background(), folder, bytes.NewReader(data), int64(len(data)))
+}
+
+// UpdateContext ...
+func (c *Client) UpdateContext(ctx context.Context, folder *Folder, data []byte) error {
+	return c.UpdateFromContext(ctx, folder, bytes.NewReader(data), int64(len(data)))
+}
+
+// UpdateFrom replaces the data of a file with length bytes from r
+func (c *Client) UpdateFrom(folder *Folder, r io.Reader, length int64) error {
+	return c.UpdateFromContext(context.Background(), folder, r, length)
+}
+
+// UpdateFromContext ...
+func (c *Client) UpdateFromContext(ctx context.Context, folder *Folder, r io.Reader, length int64) error {
+	file, err := c.getFileDetails(ctx, folder.Parent, folder.Index)
+	if err != nil {
+		return err
+	}
+
+	if file == nil {
+		return ErrNotExist
+	}
+
+	if file.Meta.Type != "file" {
+		return ErrNotFile
+	}
+
+	version, err := file.KeyFile.GetVersion()
+	if err != nil {
+		return err
+	}
+
+	meta := *file.Meta
+	now := time.Now().UTC()
+	meta.Modified = &now
+
+	err = c.createFile(ctx, folder.Parent, folder.Index, version+1, meta, r, length, c.Options, file.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	return c.reloadFile(ctx, folder)
+}
+
+// LsRevisions lists the versions of a file whose blocks are kept, newest
+// first
+func (c *Client) LsRevisions(folder *Folder) ([]FileRevision, error) {
+	return c.LsRevisionsContext(context.Background(), folder)
+}
+
+// LsRevisionsContext ...
+func (c *Client) LsRevisionsContext(ctx context.Context, folder *Folder) ([]FileRevision, error) {
+	file, err := c.getFileDetails(ctx, folder.Parent, folder.Index)
+	if err != nil {
+		return nil, err
+	}
+
+	if file == nil {
+		return nil, ErrNotExist
+	}
+
+	version, err := file.KeyFile.GetVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	layout, err := file.KeyFile.GetLayout()
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := []FileRevision{{Version: version, Current: true, Meta: *file.Meta}}
+	for _, revision := range layout.Revisions {
+		keyFile, err := file.KeyFile.Revision(revision)
+		if err != nil {
+			return nil, err
+		}
+
+		meta, err := c.readMeta(ctx, &keyFile)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, FileRevision{Version: revision.Version, Meta: meta})
+	}
+
+	return revisions, nil
+}
+
+// OpenRevision returns a reader over a version of a file
+func (c *Client) OpenRevision(folder *Folder, version uint32) (*FileReader, error) {
+	return c.OpenRevisionContext(context.Background(), folder, version)
+}
+
+// OpenRevisionContext ...
+func (c *Client) OpenRevisionContext(ctx context.Context, folder *Folder, version uint32) (*FileReader, error) {
+	file, err := c.getFileDetails(ctx, folder.Parent, folder.Index)
+	if err != nil {
+		return nil, err
+	}
+
+	if file == nil {
+		return nil, ErrNotExist
+	}
+
+	current, err := file.KeyFile.GetVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	if version == current {
+		return c.openKeyFile(ctx, file.KeyFile)
+	}
+
+	layout, err := file.KeyFile.GetLayout()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, revision := range layout.Revisions {
+		if revision.Version != version {
+			continue
+		}
+
+		keyFile, err := file.KeyFile.Revision(revision)
+		if err != nil {
+			return nil, err
+		}
+		return c.openKeyFile(ctx, &keyFile)
+	}
+
+	return nil, fmt.Errorf("%w: revision %d", ErrNotExist, version)
+}
+
+// Rollback replaces a file with one of its previous versions, as its next
+// version, keeping the version it replaces, and returns the file
+func (c *Client) Rollback(folder *Folder, version uint32) (*Folder, error) {
+	return c.RollbackContext(context.Background(), folder, version)
+}
+
+// RollbackContext ...
+func (c *Client) RollbackContext(ctx context.Context, folder *Folder, version uint32) (*Folder, error) {
+	file, err := c.getFileDetails(ctx, folder.Parent, folder.Index)
+	if err != nil {
+		return nil, err
+	}
+
+	if file == nil {
+		return nil, ErrNotExist
+	}
+
+	keyFile, dropped, err := core.Rollback(*file.KeyFile, version, c.Revisions)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.uploadFile(ctx, core.File{Key: file.Key, KeyFile: keyFile})
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.freeRevisions(ctx, file.KeyFile, dropped)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.reloadFile(ctx, folder)
+	if err != nil {
+		return nil, err
+	}
+
+	return folder, nil
+}
+
+// uploadRevision uploads a new file, as the next version of previous if it
+// is not nil, and returns the revisions no longer kept, whose blocks are
+// freed with freeRevisions once the file is uploaded. If it fails, the
+// blocks it uploaded and those in uploaded are deleted.
+func (c *Client) uploadRevision(ctx context.Context, file core.File, previous *core.KeyFile, uploaded []string) ([]core.Revision, error) {
+	var dropped []core.Revision
+	var err error
+	if previous != nil {
+		dropped, err = file.KeyFile.KeepRevisions(*previous, c.Revisions)
+		if err == nil {
+			err = file.KeyFile.Sign()
+		}
+	}
+
+	if err == nil {
+		var stored []string
+		stored, err = c.storeFile(ctx, file)
+		uploaded = append(uploaded, stored...)
+	}
+
+	if err != nil {
+		return nil, cleanUp(err, func(ctx context.Context) error {
+			return c.deleteBlocks(ctx, uploaded)
+		})
+	}
+
+	return dropped, nil
+}
+
+// freeRevisions deletes the blocks of revisions of a key file and releases
+// their chunks
+func (c *Client) freeRevisions(ctx context.Context, keyFile *core.KeyFile, revisions []core.Revision) error {
+	removals, err := c.planRevisions(ctx, keyFile, revisions)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range removals {
+		err = c.remove(ctx, r)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reloadFile reads the key file and metadata of a file again after it is
+// replaced
+func (c *Client) reloadFile(ctx context.Context, folder *Folder) error {
+	delete(folder.Parent.Children, folder.Index)
+	file, err := c.getFileDetails(ctx, folder.Parent, folder.Index)
+	if err != nil {
+		return err
+	}
+
+	if file == nil {
+		return ErrNotExist
+	}
+
+	folder.KeyFile, folder.Meta = file.KeyFile, file.Meta
+	return nil
+}
@@ -0,0 +1,329 @@
+package client
+
+import (
+	"context"
+
+	"github.com/beritani/whitebox/core"
+)
+
+// removal is a file whose key file is replaced and whose blocks are deleted.
+// The key file of a revision was already replaced, so only its blocks are.
+type removal struct {
+	parent   *Folder
+	index    uint32
+	keyFile  *core.KeyFile
+	revision bool
+	blocks   []string
+	chunkIDs []string
+	owner    string
+}
+
+// Purge removes a file, or a folder and everything in it, whether or not it
+// is in the trash
+func (c *Client) Purge(folder *Folder) error {
+	return c.PurgeContext(context.Background(), folder)
+}
+
+// PurgeContext removes depth first, replacing each key file with one signed
+// by the next owner without any data and deleting its blocks
+func (c *Client) PurgeContext(ctx context.Context, folder *Folder) error {
+	err := c.refresh(ctx, folder.Parent)
+	if err != nil {
+		return err
+	}
+
+	removals, err := c.planRm(ctx, folder)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range removals {
+		err = c.remove(ctx, r)
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.refresh(ctx, folder.Parent)
+}
+
+// RmPlan lists the IDs removing a file would change: the key files replaced,
+// the blocks deleted, and the shared chunks and reference records deleted as
+// no other file uses them
+type RmPlan struct {
+	KeyFiles []string
+	Blocks   []string
+	Chunks   []string
+}
+
+// RmDryRun returns what Rm would change without changing anything
+func (c *Client) RmDryRun(folder *Folder) (RmPlan, error) {
+	return c.RmDryRunContext(context.Background(), folder)
+}
+
+// RmDryRunContext ...
+func (c *Client) RmDryRunContext(ctx context.Context, folder *Folder) (RmPlan, error) {
+	err := c.refresh(ctx, folder.Parent)
+	if err != nil {
+		return RmPlan{}, err
+	}
+
+	plan := RmPlan{KeyFiles: []string{}, Blocks: []string{}, Chunks: []string{}}
+
+	// Moving to the Trash Only Replaces the Key File
+	if c.Retention > 0 {
+		file, err := c.getFileDetails(ctx, folder.Parent, folder.Index)
+		if err != nil {
+			return RmPlan{}, err
+		}
+
+		if file == nil {
+			return RmPlan{}, ErrNotExist
+		}
+
+		if canTrash(file.KeyFile) {
+			keyID, err := file.KeyFile.ID()
+			if err != nil {
+				return RmPlan{}, err
+			}
+			plan.KeyFiles = append(plan.KeyFiles, keyID)
+			return plan, nil
+		}
+	}
+
+	removals, err := c.planRm(ctx, folder)
+	if err != nil {
+		return RmPlan{}, err
+	}
+
+	for _, r := range removals {
+		plan.Blocks = append(plan.Blocks, r.blocks...)
+		if r.revision {
+			continue
+		}
+
+		keyID, err := r.keyFile.ID()
+		if err != nil {
+			return RmPlan{}, err
+		}
+		plan.KeyFiles = append(plan.KeyFiles, keyID)
+	}
+
+	plan.Chunks, err = c.planChunks(ctx, removals)
+	if err != nil {
+		return RmPlan{}, err
+	}
+
+	return plan, nil
+}
+
+// planRm returns the removals of a file then its revisions, or of a folder
+// after everything in it, depth first, including files in the trash
+func (c *Client) planRm(ctx context.Context, folder *Folder) ([]removal, error) {
+	file, err := c.getEntry(ctx, folder.Parent, folder.Index)
+	if err != nil {
+		return nil, err
+	}
+
+	if file == nil {
+		return nil, ErrNotExist
+	}
+
+	removals := []removal{}
+	if file.Meta.Type == "folder" {
+		count, err := c.getChildCount(ctx, file)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := uint32(1); i <= count; i++ {
+			child, err := c.getEntry(ctx, file, i)
+			if err != nil {
+				return nil, err
+			}
+
+			if child == nil {
+				continue
+			}
+
+			children, err := c.planRm(ctx, child)
+			if err != nil {
+				return nil, err
+			}
+			removals = append(removals, children...)
+		}
+	}
+
+	r, err := c.planRemoval(ctx, file.KeyFile, file.Meta.Type == "file")
+	if err != nil {
+		return nil, err
+	}
+	r.parent, r.index = file.Parent, file.Index
+	removals = append(removals, r)
+
+	// Revisions After the Key File Recording Them is Replaced
+	layout, err := file.KeyFile.GetLayout()
+	if err != nil {
+		return nil, err
+	}
+
+	revisions, err := c.planRevisions(ctx, file.KeyFile, layout.Revisions)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(removals, revisions...), nil
+}
+
+// planRevisions returns the removals of revisions of a key file
+func (c *Client) planRevisions(ctx context.Context, keyFile *core.KeyFile, revisions []core.Revision) ([]removal, error) {
+	removals := []removal{}
+	for _, revision := range revisions {
+		revisionKeyFile, err := keyFile.Revision(revision)
+		if err != nil {
+			return nil, err
+		}
+
+		r, err := c.planRemoval(ctx, &revisionKeyFile, true)
+		if err != nil {
+			return nil, err
+		}
+		r.revision = true
+		removals = append(removals, r)
+	}
+
+	return removals, nil
+}
+
+// planRemoval returns the blocks and shared chunks of a key file
+func (c *Client) planRemoval(ctx context.Context, keyFile *core.KeyFile, isFile bool) (removal, error) {
+	r := removal{keyFile: keyFile}
+
+	metaSet, err := metaBlockSet(keyFile)
+	if err != nil {
+		return removal{}, err
+	}
+
+	r.blocks, err = c.getBlockIds(ctx, metaSet)
+	if err != nil {
+		return removal{}, err
+	}
+
+	// Folders Only Have File Blocks with Padding or Parity
+	fileSet, err := fileBlockSet(keyFile)
+	if err != nil {
+		return removal{}, err
+	}
+
+	if isFile || fileSet.bound {
+		fileBlockIds, err := c.getBlockIds(ctx, fileSet)
+		if err != nil {
+			return removal{}, err
+		}
+		r.blocks = append(r.blocks, fileBlockIds...)
+
+		layout, err := keyFile.GetLayout()
+		if err != nil {
+			return removal{}, err
+		}
+
+		if layout.Chunking == core.ChunkingContent {
+			err = c.loadTree(ctx, &fileSet)
+			if err != nil {
+				return removal{}, err
+			}
+
+			manifest, err := c.getManifest(ctx, fileSet)
+			if err != nil {
+				return removal{}, err
+			}
+			r.chunkIDs = manifest.ChunkIDs()
+			r.owner = fileSet.fileID
+		}
+	}
+
+	return r, nil
+}
+
+// remove replaces the key file of a removal, signed by the next owner, then
+// deletes its blocks and releases its chunks
+func (c *Client) remove(ctx context.Context, r removal) error {
+	if !r.revision {
+		version, err := r.keyFile.GetVersion()
+		if err != nil {
+			return err
+		}
+
+		newFile, err := core.CreateTombstone(r.parent.Key, r.index, version+1)
+		if err != nil {
+			return err
+		}
+
+		err = c.uploadFile(ctx, newFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := c.deleteBlocks(ctx, r.blocks)
+	if err != nil {
+		return err
+	}
+
+	if len(r.chunkIDs) == 0 {
+		return nil
+	}
+
+	keys, err := c.getChunkKeys()
+	if err != nil {
+		return err
+	}
+
+	return c.releaseChunks(ctx, keys, r.owner, r.chunkIDs, nil)
+}
+
+// planChunks returns the chunks and reference records removals would delete,
+// as every file using them is removed
+func (c *Client) planChunks(ctx context.Context, removals []removal) ([]string, error) {
+	removed := map[string]map[string]bool{}
+	order := []string{}
+	for _, r := range removals {
+		for _, chunkID := range r.chunkIDs {
+			if removed[chunkID] == nil {
+				removed[chunkID] = map[string]bool{}
+				order = append(order, chunkID)
+			}
+			removed[chunkID][r.owner] = true
+		}
+	}
+
+	if len(order) == 0 {
+		return []string{}, nil
+	}
+
+	keys, err := c.getChunkKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, chunkID := range order {
+		owners, err := c.getChunkRefs(ctx, keys, chunkID)
+		if err != nil {
+			return nil, err
+		}
+
+		remaining := 0
+		for _, owner := range owners {
+			if !removed[chunkID][owner] {
+				remaining++
+			}
+		}
+
+		if remaining == 0 {
+			ids = append(ids, chunkID, keys.RefID(chunkID))
+		}
+	}
+
+	return ids, nil
+}
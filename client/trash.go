@@ -0,0 +1,365 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beritani/whitebox/core"
+)
+
+// Files are moved to the trash by Rm when Retention is set. Their key files
+// are replaced by the next version marked Trashed, with the same salts, so
+// their blocks are kept and Restore replaces them again. Files in a trashed
+// folder are left as they are and are restored with it. Trashed files are
+// hidden from listings and paths, their indices are not reused, and
+// PurgeExpired removes them once Retention has passed since they were
+// trashed. Key files written before format FormatBound have no layout to
+// mark, and their blocks are keyed differently, so those files are purged
+// rather than trashed.
+
+// TrashEntry is a file in the trash. Path names it by the indices of its
+// folders and itself, such as /#2/#5, as names do not resolve to trashed
+// files.
+type TrashEntry struct {
+	Folder  *Folder
+	Path    string
+	Trashed time.Time
+	Expires time.Time
+}
+
+// TrashError reports the files in the trash, and the folders holding them,
+// that could not be listed or purged, by path. It unwraps to the error of
+// the first of them.
+type TrashError struct {
+	Errs map[string]error
+}
+
+func (e *TrashError) Error() string {
+	failures := []string{}
+	for _, p := range e.paths() {
+		failures = append(failures, fmt.Sprintf("%s: %v", p, e.Errs[p]))
+	}
+	return fmt.Sprintf("Could not handle %d files in the trash: %s", len(e.Errs), strings.Join(failures, "; "))
+}
+
+// Unwrap returns the error of the first file that could not be handled
+func (e *TrashError) Unwrap() error {
+	return e.Errs[e.paths()[0]]
+}
+
+func (e *TrashError) paths() []string {
+	paths := []string{}
+	for p := range e.Errs {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Trash moves a file, or a folder and everything in it, to the trash, or
+// purges it if its key file predates layouts
+func (c *Client) Trash(folder *Folder) error {
+	return c.TrashContext(context.Background(), folder)
+}
+
+// TrashContext ...
+func (c *Client) TrashContext(ctx context.Context, folder *Folder) error {
+	if folder.Parent == folder {
+		return ErrRoot
+	}
+
+	err := c.refresh(ctx, folder.Parent)
+	if err != nil {
+		return err
+	}
+
+	file, err := c.getFileDetails(ctx, folder.Parent, folder.Index)
+	if err != nil {
+		return err
+	}
+
+	if file == nil {
+		return ErrNotExist
+	}
+
+	if !canTrash(file.KeyFile) {
+		return c.PurgeContext(ctx, file)
+	}
+
+	now := time.Now().UTC()
+	err = c.reviseTrashed(ctx, file, &now)
+	if err != nil {
+		return err
+	}
+
+	return c.refresh(ctx, folder.Parent)
+}
+
+// Restore brings a file back from the trash, returning it
+func (c *Client) Restore(folder *Folder) (*Folder, error) {
+	return c.RestoreContext(context.Background(), folder)
+}
+
+// RestoreContext ...
+func (c *Client) RestoreContext(ctx context.Context, folder *Folder) (*Folder, error) {
+	file, err := c.getTrashed(ctx, folder.Parent, folder.Index)
+	if err != nil {
+		return nil, err
+	}
+
+	if file == nil {
+		return nil, ErrNotExist
+	}
+
+	err = c.reviseTrashed(ctx, file, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.refresh(ctx, folder.Parent)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.getFileDetails(ctx, folder.Parent, folder.Index)
+}
+
+// LsTrash lists the trash in a folder and the folders in it
+func (c *Client) LsTrash(folder *Folder) ([]TrashEntry, error) {
+	return c.LsTrashContext(context.Background(), folder)
+}
+
+// LsTrashContext is LsTrash skipping the files that cannot be read, which
+// are reported in a TrashError along with the files that can
+func (c *Client) LsTrashContext(ctx context.Context, folder *Folder) ([]TrashEntry, error) {
+	failed := map[string]error{}
+	entries, err := c.lsTrash(ctx, folder, failed)
+	if err != nil {
+		return nil, err
+	}
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	if len(failed) > 0 {
+		return entries, &TrashError{Errs: failed}
+	}
+
+	return entries, nil
+}
+
+// lsTrash lists the trash in a folder and the folders in it, recording the
+// files that cannot be read in failed
+func (c *Client) lsTrash(ctx context.Context, folder *Folder, failed map[string]error) ([]TrashEntry, error) {
+	count, err := c.getChildCount(ctx, folder)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []TrashEntry{}
+	for i := uint32(1); i <= count; i++ {
+		p := trashPath(folder) + "/#" + strconv.FormatUint(uint64(i), 10)
+		child, err := c.getFileDetails(ctx, folder, i)
+		if err != nil {
+			failed[p] = err
+			continue
+		}
+
+		if child != nil {
+			if child.Meta.Type != "folder" {
+				continue
+			}
+
+			children, err := c.lsTrash(ctx, child, failed)
+			if err != nil {
+				failed[p] = err
+				continue
+			}
+			entries = append(entries, children...)
+			continue
+		}
+
+		trashed, err := c.getTrashed(ctx, folder, i)
+		if err != nil {
+			failed[p] = err
+			continue
+		}
+
+		if trashed == nil {
+			continue
+		}
+
+		layout, err := trashed.KeyFile.GetLayout()
+		if err != nil {
+			failed[p] = err
+			continue
+		}
+
+		entries = append(entries, TrashEntry{
+			Folder:  trashed,
+			Path:    trashPath(trashed),
+			Trashed: *layout.Trashed,
+			Expires: layout.Trashed.Add(c.Retention),
+		})
+	}
+
+	return entries, nil
+}
+
+// GetTrashed returns the file in the trash at a path from folder, whose last
+// part is its index, such as /photos/#3
+func (c *Client) GetTrashed(folder *Folder, p string) (*Folder, error) {
+	return c.GetTrashedContext(context.Background(), folder, p)
+}
+
+// GetTrashedContext ...
+func (c *Client) GetTrashedContext(ctx context.Context, folder *Folder, p string) (*Folder, error) {
+	dir, segment := path.Split(path.Clean(p))
+	parent, err := c.GetFolderFromPathContext(ctx, folder, dir+".")
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := strconv.ParseUint(strings.TrimPrefix(segment, "#"), 10, 32)
+	if err != nil {
+		return nil, ErrNotExist
+	}
+
+	file, err := c.getTrashed(ctx, parent, uint32(index))
+	if err != nil {
+		return nil, err
+	}
+
+	if file == nil {
+		return nil, ErrNotExist
+	}
+
+	return file, nil
+}
+
+// PurgeExpired purges the files in the trash that were trashed longer than
+// Retention ago, returning how many were purged. Every file in the trash has
+// expired if Retention is 0.
+func (c *Client) PurgeExpired() (int, error) {
+	return c.PurgeExpiredContext(context.Background())
+}
+
+// PurgeExpiredContext is PurgeExpired skipping the files that cannot be read
+// or purged, which are reported in a TrashError once the rest are purged
+func (c *Client) PurgeExpiredContext(ctx context.Context) (int, error) {
+	failed := map[string]error{}
+	entries, err := c.lsTrash(ctx, c.Root(), failed)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	purged := 0
+	for _, entry := range entries {
+		if now.Before(entry.Expires) {
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return purged, ctx.Err()
+		}
+
+		err = c.PurgeContext(ctx, entry.Folder)
+		if err != nil {
+			failed[entry.Path] = err
+			continue
+		}
+		purged++
+	}
+
+	if ctx.Err() != nil {
+		return purged, ctx.Err()
+	}
+
+	if len(failed) > 0 {
+		return purged, &TrashError{Errs: failed}
+	}
+
+	return purged, nil
+}
+
+// reviseTrashed replaces the key file of a file with the next version,
+// trashed at trashed or restored if it is nil
+func (c *Client) reviseTrashed(ctx context.Context, file *Folder, trashed *time.Time) error {
+	layout, err := file.KeyFile.GetLayout()
+	if err != nil {
+		return err
+	}
+	layout.Trashed = trashed
+
+	keyFile, err := core.ReviseLayout(*file.KeyFile, layout)
+	if err != nil {
+		return err
+	}
+
+	return c.uploadFile(ctx, core.File{Key: file.Key, KeyFile: keyFile})
+}
+
+// getTrashed returns the file at index in parent if it is in the trash. It
+// is not cached, as listings do not include it.
+func (c *Client) getTrashed(ctx context.Context, parent *Folder, index uint32) (*Folder, error) {
+	_, cached := parent.Children[index]
+	keyFile, err := c.getKeyFile(ctx, parent, index)
+	if err != nil || keyFile == nil || !isTrashed(keyFile) {
+		if !cached {
+			delete(parent.Children, index)
+		}
+		return nil, err
+	}
+
+	_, err = c.getMeta(ctx, parent, index)
+	file := parent.Children[index]
+	delete(parent.Children, index)
+	if err != nil {
+		return nil, err
+	}
+
+	file.Index = index
+	file.Parent = parent
+	file.Path = path.Join(parent.Path, strconv.FormatUint(uint64(index), 10))
+	file.Children = map[uint32]Folder{}
+	return &file, nil
+}
+
+// getEntry returns the file at index in parent, whether or not it is in the
+// trash
+func (c *Client) getEntry(ctx context.Context, parent *Folder, index uint32) (*Folder, error) {
+	file, err := c.getFileDetails(ctx, parent, index)
+	if err != nil || file != nil {
+		return file, err
+	}
+
+	return c.getTrashed(ctx, parent, index)
+}
+
+// canTrash returns true for key files with a layout to mark Trashed
+func canTrash(keyFile *core.KeyFile) bool {
+	return keyFile.Format >= core.FormatBound
+}
+
+// isTrashed returns true for the key files of files in the trash
+func isTrashed(keyFile *core.KeyFile) bool {
+	layout, err := keyFile.GetLayout()
+	return err == nil && layout.Trashed != nil
+}
+
+// trashPath returns the path of a file by the indices of its folders and
+// itself
+func trashPath(file *Folder) string {
+	p := ""
+	for f := file; f.Parent != f; f = f.Parent {
+		p = "/#" + strconv.FormatUint(uint64(f.Index), 10) + p
+	}
+	return p
+}
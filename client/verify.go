@@ -0,0 +1,49 @@
+package client
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/beritani/whitebox/core"
+)
+
+// VerifyPolicy sets how key file signatures are checked when loaded
+type VerifyPolicy int
+
+const (
+	// VerifyStrict fails to load key files with invalid signatures
+	VerifyStrict VerifyPolicy = iota
+	// VerifyWarn logs invalid signatures and loads the key file anyway
+	VerifyWarn
+	// VerifyOff skips signature checks, for legacy data
+	VerifyOff
+)
+
+// ParseVerifyPolicy returns the policy named strict, warn or off
+func ParseVerifyPolicy(name string) (VerifyPolicy, error) {
+	switch name {
+	case "strict":
+		return VerifyStrict, nil
+	case "warn":
+		return VerifyWarn, nil
+	case "off":
+		return VerifyOff, nil
+	default:
+		return VerifyStrict, fmt.Errorf("Unknown verify policy %q", name)
+	}
+}
+
+// checkKeyFile applies the verify policy to a loaded key file
+func (c *Client) checkKeyFile(keyFile *core.KeyFile) error {
+	if c.Verify == VerifyOff {
+		return nil
+	}
+
+	err := keyFile.Check()
+	if err != nil && c.Verify == VerifyWarn {
+		log.Printf("Warning: %v", err)
+		return nil
+	}
+
+	return err
+}
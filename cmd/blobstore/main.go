@@ -0,0 +1,8 @@
+package main
+
+import "github.com/beritani/whitebox/api"
+
+func main() {
+	// Start Blob Store
+	api.StartBlobStore()
+}
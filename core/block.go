@@ -1,16 +1,30 @@
 package core
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
+	"fmt"
+	"io"
 	"math"
 )
 
+// BlockFormatBinary is the version byte of binary encoded blocks, which
+// cannot be confused with the opening brace of legacy JSON blocks
+const BlockFormatBinary = 1
+
+// blockHeaderSize is the size of the binary block header: format version,
+// flags, count and padding
+const blockHeaderSize = 10
+
 // Block Object
 type Block struct {
 	id      string
+	ad      []byte
 	Data    []byte
 	Padding int
 	Count   int
+	Flags   uint8 `json:"-"`
 }
 
 // EncryptedBlock Object
@@ -19,16 +33,49 @@ type EncryptedBlock struct {
 	Data []byte
 }
 
+// MarshalBinary encodes the block header followed by the raw block data
+func (b Block) MarshalBinary() ([]byte, error) {
+	data := make([]byte, blockHeaderSize+len(b.Data))
+	data[0] = BlockFormatBinary
+	data[1] = b.Flags
+	binary.BigEndian.PutUint32(data[2:6], uint32(b.Count))
+	binary.BigEndian.PutUint32(data[6:10], uint32(b.Padding))
+	copy(data[blockHeaderSize:], b.Data)
+	return data, nil
+}
+
+// UnmarshalBinary decodes a binary encoded block
+func (b *Block) UnmarshalBinary(data []byte) error {
+	if len(data) < blockHeaderSize {
+		return fmt.Errorf("Block too short")
+	}
+
+	if data[0] != BlockFormatBinary {
+		return fmt.Errorf("Unknown block format %d", data[0])
+	}
+
+	b.Flags = data[1]
+	b.Count = int(binary.BigEndian.Uint32(data[2:6]))
+	b.Padding = int(binary.BigEndian.Uint32(data[6:10]))
+	b.Data = data[blockHeaderSize:]
+
+	if b.Padding > len(b.Data) {
+		return fmt.Errorf("Block padding larger than data")
+	}
+
+	return nil
+}
+
 // Encrypt ...
 func (b Block) Encrypt(key []byte) (EncryptedBlock, error) {
 	// Marshal Block
-	blockData, err := json.Marshal(b)
+	blockData, err := b.MarshalBinary()
 	if err != nil {
 		return EncryptedBlock{}, err
 	}
 
 	// Encrypt Block Data
-	encryptedBlockData, err := Encrypt(key, blockData)
+	encryptedBlockData, err := EncryptWithAD(key, blockData, b.ad)
 	if err != nil {
 		return EncryptedBlock{}, err
 	}
@@ -41,15 +88,24 @@ func (b Block) Encrypt(key []byte) (EncryptedBlock, error) {
 
 // Decrypt ...
 func (b EncryptedBlock) Decrypt(key []byte) (Block, error) {
+	return b.DecryptWithAD(key, nil)
+}
+
+// DecryptWithAD decrypts a block bound to additional data
+func (b EncryptedBlock) DecryptWithAD(key []byte, ad []byte) (Block, error) {
 	// Decrypt Data
-	blockData, err := Decrypt(key, b.Data)
+	blockData, err := DecryptWithAD(key, b.Data, ad)
 	if err != nil {
 		return Block{}, err
 	}
 
-	// Unmarshal
+	// Unmarshal Legacy JSON or Binary Block
 	var block Block
-	err = json.Unmarshal(blockData, &block)
+	if len(blockData) > 0 && blockData[0] == '{' {
+		err = json.Unmarshal(blockData, &block)
+	} else {
+		err = block.UnmarshalBinary(blockData)
+	}
 	if err != nil {
 		return Block{}, err
 	}
@@ -79,13 +135,81 @@ func CreateBlocks(fileID string, data []byte, size int) []Block {
 
 // CreateEncryptedBlocks ...
 func CreateEncryptedBlocks(fileID string, key []byte, data []byte, size int) (encryptedBlocks []EncryptedBlock, err error) {
-	blocks := CreateBlocks(fileID, data, size)
-	encryptedBlocks = make([]EncryptedBlock, len(blocks))
-	for i, block := range blocks {
-		encryptedBlocks[i], err = block.Encrypt(key)
+	encryptedBlocks = make([]EncryptedBlock, 0, BlockCount(int64(len(data)), size))
+	err = CreateEncryptedBlocksFromReader(fileID, key, bytes.NewReader(data), int64(len(data)), size, func(block EncryptedBlock) error {
+		encryptedBlocks = append(encryptedBlocks, block)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return encryptedBlocks, nil
+}
+
+// CreateEncryptedBlocksFromReader reads length bytes from r and passes each
+// encrypted block to fn in order, holding only one block in memory at a time.
+// Blocks are bound to their position with BlockAD.
+func CreateEncryptedBlocksFromReader(fileID string, key []byte, r io.Reader, length int64, size int, fn func(EncryptedBlock) error) error {
+	return createEncryptedBlocks(fileID, key, r, length, size, BlockCount(length, size), fn)
+}
+
+// createEncryptedBlocks is CreateEncryptedBlocksFromReader padded with
+// blocks without data up to count blocks
+func createEncryptedBlocks(fileID string, key []byte, r io.Reader, length int64, size int, count int, fn func(EncryptedBlock) error) error {
+	if size <= 0 {
+		return fmt.Errorf("Block size must be greater than 0")
+	}
+
+	slice := make([]byte, size)
+
+	for i := 0; i < count; i++ {
+		n := size
+		if remaining := length - int64(i)*int64(size); remaining <= 0 {
+			n = 0
+		} else if remaining < int64(size) {
+			n = int(remaining)
+		}
+
+		if _, err := io.ReadFull(r, slice[:n]); err != nil {
+			return err
+		}
+
+		// Zero Padding
+		for j := n; j < size; j++ {
+			slice[j] = 0
+		}
+
+		id := BlockID(fileID, i)
+		block := Block{
+			id:      id,
+			ad:      BlockAD(fileID, id, i, count),
+			Count:   count,
+			Data:    slice,
+			Padding: size - n,
+		}
+
+		encryptedBlock, err := block.Encrypt(key)
+		if err != nil {
+			return err
+		}
+
+		err = fn(encryptedBlock)
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
-	return encryptedBlocks, nil
+
+	return nil
+}
+
+// BlockAD returns the additional data that binds a block to its file, its
+// position and the total number of blocks, so blocks that are reordered,
+// truncated or swapped with blocks of other files fail to decrypt
+func BlockAD(fileID string, blockID string, index int, count int) []byte {
+	return []byte(fmt.Sprintf("whitebox/block/v1:%s:%s:%d:%d", fileID, blockID, index, count))
+}
+
+// BlockCount returns the number of blocks needed to store length bytes
+func BlockCount(length int64, size int) int {
+	return int((length + int64(size) - 1) / int64(size))
 }
@@ -0,0 +1,156 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func testKey(t *testing.T) []byte {
+	key, err := RandomBytes(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestBlockBinaryRoundTrip(t *testing.T) {
+	block := Block{Data: []byte("block data\x00\x00"), Padding: 2, Count: 3, Flags: 1}
+
+	data, err := block.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != BlockFormatBinary {
+		t.Fatalf("Block format = %d, expected %d", data[0], BlockFormatBinary)
+	}
+
+	var decoded Block
+	err = decoded.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.Data, block.Data) || decoded.Padding != block.Padding || decoded.Count != block.Count || decoded.Flags != block.Flags {
+		t.Fatalf("Decoded block = %+v, expected %+v", decoded, block)
+	}
+}
+
+func TestBlockUnmarshalInvalid(t *testing.T) {
+	valid, _ := Block{Data: []byte("abc"), Padding: 1}.MarshalBinary()
+
+	tooMuchPadding := append([]byte{}, valid...)
+	tooMuchPadding[9] = 4
+
+	unknownFormat := append([]byte{}, valid...)
+	unknownFormat[0] = 2
+
+	for name, data := range map[string][]byte{
+		"short":           valid[:blockHeaderSize-1],
+		"unknown format":  unknownFormat,
+		"padding too big": tooMuchPadding,
+	} {
+		var block Block
+		if err := block.UnmarshalBinary(data); err == nil {
+			t.Errorf("UnmarshalBinary of %s block succeeded", name)
+		}
+	}
+}
+
+func TestEncryptedBlocksRoundTrip(t *testing.T) {
+	key := testKey(t)
+	data := bytes.Repeat([]byte("0123456789"), 25)
+
+	for _, size := range []int{1, 16, 250, 256} {
+		blocks, err := CreateEncryptedBlocks("file", key, data, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(blocks) != BlockCount(int64(len(data)), size) {
+			t.Fatalf("Created %d blocks of size %d, expected %d", len(blocks), size, BlockCount(int64(len(data)), size))
+		}
+
+		var decrypted []Block
+		for i, encrypted := range blocks {
+			if encrypted.ID != BlockID("file", i) {
+				t.Fatalf("Block %d has id %s, expected %s", i, encrypted.ID, BlockID("file", i))
+			}
+
+			block, err := encrypted.DecryptWithAD(key, BlockAD("file", encrypted.ID, i, len(blocks)))
+			if err != nil {
+				t.Fatalf("Block %d of size %d: %v", i, size, err)
+			}
+			decrypted = append(decrypted, block)
+		}
+
+		if got := RecreateFile(decrypted); !bytes.Equal(got, data) {
+			t.Fatalf("Recreated file of block size %d = %q, expected %q", size, got, data)
+		}
+	}
+}
+
+func TestBlockADBinding(t *testing.T) {
+	key := testKey(t)
+	blocks, err := CreateEncryptedBlocks("file", key, []byte("first block,second block"), 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := blocks[1]
+
+	// Blocks Only Decrypt at their Own Position, Count and File
+	for name, ad := range map[string][]byte{
+		"no additional data": nil,
+		"other file":         BlockAD("other", block.ID, 1, 2),
+		"other position":     BlockAD("file", blocks[0].ID, 0, 2),
+		"truncated file":     BlockAD("file", block.ID, 1, 1),
+		"extended file":      BlockAD("file", block.ID, 1, 3),
+	} {
+		if _, err := block.DecryptWithAD(key, ad); err == nil {
+			t.Errorf("Block decrypted with %s", name)
+		}
+	}
+
+	// Tampered Data Fails to Decrypt
+	tampered := EncryptedBlock{ID: block.ID, Data: append([]byte{}, block.Data...)}
+	tampered.Data[len(tampered.Data)/2] ^= 1
+	if _, err := tampered.DecryptWithAD(key, BlockAD("file", block.ID, 1, 2)); err == nil {
+		t.Error("Tampered block decrypted")
+	}
+
+	if _, err := block.DecryptWithAD(testKey(t), BlockAD("file", block.ID, 1, 2)); err == nil {
+		t.Error("Block decrypted with another key")
+	}
+}
+
+func TestLegacyJSONBlock(t *testing.T) {
+	key := testKey(t)
+
+	// Blocks Written Before the Binary Format
+	legacy, err := json.Marshal(Block{Data: []byte("legacy\x00\x00\x00"), Padding: 3, Count: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := Encrypt(key, legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := EncryptedBlock{ID: "legacy", Data: encrypted}.Decrypt(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(block.Data) != "legacy" || block.Count != 1 {
+		t.Fatalf("Legacy block = %+v", block)
+	}
+
+	// Legacy Unbound Blocks
+	blocks := CreateBlocks("legacy", []byte("unbound legacy blocks"), 8)
+	for _, b := range blocks {
+		encrypted, err := b.Encrypt(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := encrypted.Decrypt(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
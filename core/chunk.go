@@ -0,0 +1,288 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/decred/dcrd/hdkeychain/v3"
+)
+
+// Chunking modes
+const (
+	// ChunkingFixed splits file data into blocks of the block size
+	ChunkingFixed = ""
+	// ChunkingContent splits file data where a rolling hash of its content
+	// matches, so the unchanged parts of an edited file split into the same
+	// chunks. Chunk IDs and keys are keyed hashes of the chunk content scoped
+	// to the account, so identical chunks are stored once per account, and
+	// the file blocks store the Manifest of chunks instead of the data.
+	ChunkingContent = "content"
+)
+
+// ParseChunking returns the chunking named fixed or content
+func ParseChunking(name string) (string, error) {
+	switch name {
+	case "fixed", ChunkingFixed:
+		return ChunkingFixed, nil
+	case ChunkingContent:
+		return ChunkingContent, nil
+	default:
+		return ChunkingFixed, fmt.Errorf("Unknown chunking %q", name)
+	}
+}
+
+// ChunkKeys derive the IDs, keys and reference records of content-defined
+// chunks for an account
+type ChunkKeys struct {
+	id   []byte
+	key  []byte
+	ref  []byte
+	gear [256]uint64
+}
+
+// ChunkRef identifies and decrypts a chunk
+type ChunkRef struct {
+	ID   string `json:"ID"`
+	Key  []byte `json:"Key"`
+	Size int    `json:"Size"`
+}
+
+// Manifest lists the chunks of a file in order
+type Manifest struct {
+	Chunks []ChunkRef `json:"Chunks"`
+}
+
+// NewChunkKeys returns the chunk keys of the account with the master key
+func NewChunkKeys(master *hdkeychain.ExtendedKey) (*ChunkKeys, error) {
+	secret, err := master.SerializedPrivKey()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := &ChunkKeys{
+		id:  DeriveKey(secret, nil, chunkIDInfo),
+		key: DeriveKey(secret, nil, chunkKeyInfo),
+		ref: DeriveKey(secret, nil, chunkRefInfo),
+	}
+
+	// Keyed Rolling Hash Table, so Chunk Boundaries Depend on the Account
+	gearKey := DeriveKey(secret, nil, chunkGearInfo)
+	for i := range keys.gear {
+		keys.gear[i] = binary.BigEndian.Uint64(keyedHash(gearKey, []byte{byte(i)}))
+	}
+
+	return keys, nil
+}
+
+func keyedHash(key []byte, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// chunkSizes returns the smallest and largest chunk and the rolling hash
+// mask for a block size, which give chunks of about half the block size
+func chunkSizes(size int) (int, int, uint64) {
+	min := size / 4
+	if min < 1 {
+		min = 1
+	}
+
+	bits := uint(0)
+	for 1<<(bits+1) <= min {
+		bits++
+	}
+
+	// The high bits of the hash depend on the most bytes
+	var mask uint64
+	if bits > 0 {
+		mask = ^uint64(0) << (64 - bits)
+	}
+
+	return min, size, mask
+}
+
+// ChunkAD returns the additional data that binds a chunk to its ID
+func ChunkAD(chunkID string) []byte {
+	return []byte("whitebox/chunk/v1:" + chunkID)
+}
+
+// EncryptChunk returns the reference and encrypted block of a chunk. The
+// chunk is padded to a multiple of the smallest chunk size for the block size.
+func (k *ChunkKeys) EncryptChunk(data []byte, size int) (ChunkRef, EncryptedBlock, error) {
+	ref := ChunkRef{
+		ID:   hex.EncodeToString(keyedHash(k.id, data)),
+		Key:  keyedHash(k.key, data),
+		Size: len(data),
+	}
+
+	unit, _, _ := chunkSizes(size)
+	padded := make([]byte, (len(data)+unit-1)/unit*unit)
+	copy(padded, data)
+
+	block := Block{
+		id:      ref.ID,
+		ad:      ChunkAD(ref.ID),
+		Data:    padded,
+		Padding: len(padded) - len(data),
+		Count:   1,
+	}
+
+	encryptedBlock, err := block.Encrypt(ref.Key)
+	if err != nil {
+		return ChunkRef{}, EncryptedBlock{}, err
+	}
+
+	return ref, encryptedBlock, nil
+}
+
+// Decrypt returns the data of the chunk stored in block
+func (r ChunkRef) Decrypt(block EncryptedBlock) ([]byte, error) {
+	decrypted, err := block.DecryptWithAD(r.Key, ChunkAD(r.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(decrypted.Data) != r.Size {
+		return nil, fmt.Errorf("Chunk %s has size %d, expected %d", r.ID, len(decrypted.Data), r.Size)
+	}
+
+	return decrypted.Data, nil
+}
+
+// RefID returns the ID of the reference record of a chunk
+func (k *ChunkKeys) RefID(chunkID string) string {
+	return hex.EncodeToString(keyedHash(k.ref, []byte("ref:"+chunkID)))
+}
+
+func refAD(chunkID string) []byte {
+	return []byte("whitebox/chunk-ref/v1:" + chunkID)
+}
+
+// EncryptRefs returns the reference record of a chunk, listing the IDs of
+// the files that use it
+func (k *ChunkKeys) EncryptRefs(chunkID string, owners []string) ([]byte, error) {
+	data, err := json.Marshal(owners)
+	if err != nil {
+		return nil, err
+	}
+	return EncryptWithAD(k.ref, data, refAD(chunkID))
+}
+
+// DecryptRefs returns the IDs of the files listed in the reference record
+// of a chunk
+func (k *ChunkKeys) DecryptRefs(chunkID string, data []byte) ([]string, error) {
+	decrypted, err := DecryptWithAD(k.ref, data, refAD(chunkID))
+	if err != nil {
+		return nil, err
+	}
+
+	var owners []string
+	err = json.Unmarshal(decrypted, &owners)
+	return owners, err
+}
+
+// Chunker splits data at content-defined boundaries
+type Chunker struct {
+	r      io.Reader
+	gear   *[256]uint64
+	min    int
+	max    int
+	mask   uint64
+	buffer []byte
+	start  int
+	end    int
+	eof    bool
+}
+
+// NewChunker returns a chunker over r with chunks no larger than size
+func (k *ChunkKeys) NewChunker(r io.Reader, size int) *Chunker {
+	min, max, mask := chunkSizes(size)
+	return &Chunker{
+		r:      r,
+		gear:   &k.gear,
+		min:    min,
+		max:    max,
+		mask:   mask,
+		buffer: make([]byte, max),
+	}
+}
+
+// Next returns the next chunk, which is only valid until the following
+// call, or io.EOF after the last chunk
+func (c *Chunker) Next() ([]byte, error) {
+	// Refill Buffer
+	if c.end-c.start < c.max && !c.eof {
+		c.end = copy(c.buffer, c.buffer[c.start:c.end])
+		c.start = 0
+
+		n, err := io.ReadFull(c.r, c.buffer[c.end:])
+		c.end += n
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			c.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	// Find Boundary
+	cut := c.end
+	if c.start+c.max < cut {
+		cut = c.start + c.max
+	}
+
+	var hash uint64
+	for i := c.start; i < cut; i++ {
+		hash = hash<<1 + c.gear[c.buffer[i]]
+		if i+1-c.start >= c.min && hash&c.mask == 0 {
+			cut = i + 1
+			break
+		}
+	}
+
+	chunk := c.buffer[c.start:cut]
+	c.start = cut
+
+	return chunk, nil
+}
+
+// ParseManifest ...
+func ParseManifest(data []byte) (Manifest, error) {
+	var manifest Manifest
+	err := json.Unmarshal(data, &manifest)
+	if err != nil {
+		return Manifest{}, err
+	}
+	return manifest, nil
+}
+
+// Size returns the total size of the chunks
+func (m Manifest) Size() int64 {
+	var size int64
+	for _, chunk := range m.Chunks {
+		size += int64(chunk.Size)
+	}
+	return size
+}
+
+// ChunkIDs returns the IDs of the chunks without duplicates
+func (m Manifest) ChunkIDs() []string {
+	seen := map[string]bool{}
+	ids := []string{}
+	for _, chunk := range m.Chunks {
+		if !seen[chunk.ID] {
+			seen[chunk.ID] = true
+			ids = append(ids, chunk.ID)
+		}
+	}
+	return ids
+}
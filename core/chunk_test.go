@@ -0,0 +1,127 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func testChunkKeys(t *testing.T) *ChunkKeys {
+	_, root, err := GetRootFolder(testMnemonic, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := NewChunkKeys(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func chunks(t *testing.T, keys *ChunkKeys, data []byte, size int) [][]byte {
+	var chunks [][]byte
+	chunker := keys.NewChunker(bytes.NewReader(data), size)
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			return chunks
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, append([]byte{}, chunk...))
+	}
+}
+
+func TestChunker(t *testing.T) {
+	keys := testChunkKeys(t)
+	size := 1024
+	min, max, _ := chunkSizes(size)
+
+	data := make([]byte, 50000)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	split := chunks(t, keys, data, size)
+	if !bytes.Equal(bytes.Join(split, nil), data) {
+		t.Fatal("Chunks do not join to the data")
+	}
+	for i, chunk := range split {
+		if len(chunk) > max || (len(chunk) < min && i < len(split)-1) {
+			t.Fatalf("Chunk %d has size %d, expected %d to %d", i, len(chunk), min, max)
+		}
+	}
+
+	// Inserting Data Keeps the Chunks After It
+	edited := append(append(append([]byte{}, data[:100]...), []byte("inserted")...), data[100:]...)
+	seen := map[string]bool{}
+	for _, chunk := range split {
+		seen[string(chunk)] = true
+	}
+	kept := 0
+	for _, chunk := range chunks(t, keys, edited, size) {
+		if seen[string(chunk)] {
+			kept++
+		}
+	}
+	if kept < len(split)-3 {
+		t.Fatalf("Kept %d of %d chunks after an insert", kept, len(split))
+	}
+}
+
+func TestEncryptChunk(t *testing.T) {
+	keys := testChunkKeys(t)
+
+	ref, block, err := keys.EncryptChunk([]byte("chunk data"), 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.ID != ref.ID || ref.Size != len("chunk data") {
+		t.Fatalf("Chunk reference = %+v for block %s", ref, block.ID)
+	}
+
+	data, err := ref.Decrypt(block)
+	if err != nil || string(data) != "chunk data" {
+		t.Fatalf("Decrypt = %q, %v", data, err)
+	}
+
+	// Identical Chunks Share IDs within an Account
+	again, _, err := keys.EncryptChunk([]byte("chunk data"), 64)
+	if err != nil || again.ID != ref.ID {
+		t.Fatalf("Identical chunk has id %s, expected %s", again.ID, ref.ID)
+	}
+
+	// Chunks are Bound to their IDs and Sizes
+	other, otherBlock, _ := keys.EncryptChunk([]byte("other data"), 64)
+	if _, err := ref.Decrypt(otherBlock); err == nil {
+		t.Error("Chunk decrypted with the reference of another chunk")
+	}
+	other.Key = ref.Key
+	if _, err := other.Decrypt(block); err == nil {
+		t.Error("Chunk decrypted under another id")
+	}
+	resized := ref
+	resized.Size--
+	if _, err := resized.Decrypt(block); err == nil {
+		t.Error("Chunk decrypted with another size")
+	}
+}
+
+func TestChunkRefs(t *testing.T) {
+	keys := testChunkKeys(t)
+
+	record, err := keys.EncryptRefs("chunk", []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owners, err := keys.DecryptRefs("chunk", record)
+	if err != nil || len(owners) != 2 || owners[0] != "a" || owners[1] != "b" {
+		t.Fatalf("DecryptRefs = %v, %v", owners, err)
+	}
+
+	if _, err := keys.DecryptRefs("other", record); err == nil {
+		t.Error("Reference record decrypted for another chunk")
+	}
+}
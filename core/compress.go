@@ -0,0 +1,128 @@
+package core
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Compression algorithms
+//
+// Compressing before encryption means the number of blocks depends on the
+// content as well as the length of a file, so storage may learn how well a
+// file compresses. Only enable compression where that is acceptable.
+const (
+	CompressionNone = ""
+	CompressionGzip = "gzip"
+)
+
+// minCompressionSaving is the fraction of a file compression must save,
+// as 1/minCompressionSaving, for the compressed data to be stored
+const minCompressionSaving = 20
+
+// countWriter counts the bytes written to it
+type countWriter struct {
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+func newCompressor(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewWriterLevel(w, gzip.BestCompression)
+	default:
+		return nil, fmt.Errorf("Unknown compression %q", compression)
+	}
+}
+
+// ParseCompression returns the compression named none or gzip
+func ParseCompression(name string) (string, error) {
+	switch name {
+	case "none", CompressionNone:
+		return CompressionNone, nil
+	case CompressionGzip:
+		return CompressionGzip, nil
+	default:
+		return CompressionNone, fmt.Errorf("Unknown compression %q", name)
+	}
+}
+
+// Decompress returns a reader of the decompressed data
+func Decompress(r io.Reader, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case CompressionNone:
+		return ioutil.NopCloser(r), nil
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	default:
+		return nil, fmt.Errorf("Unknown compression %q", compression)
+	}
+}
+
+// compressReader returns a reader of length bytes of r compressed, with the
+// compressed length and the compression used, which is CompressionNone if
+// compression was skipped. The data is compressed once to measure it and
+// again while it is read, so memory use does not depend on its length. The
+// uncompressed data is written to digest once.
+func compressReader(r io.Reader, length int64, compression string, digest io.Writer) (io.ReadCloser, int64, string, error) {
+	uncompressed := ioutil.NopCloser(io.TeeReader(io.LimitReader(r, length), digest))
+	if compression == CompressionNone {
+		return uncompressed, length, CompressionNone, nil
+	}
+
+	seeker, ok := r.(io.ReadSeeker)
+	if !ok {
+		return uncompressed, length, CompressionNone, nil
+	}
+
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	// Measure Compressed Length
+	counter := &countWriter{}
+	compressor, err := newCompressor(counter, compression)
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	_, err = io.CopyN(compressor, io.TeeReader(seeker, digest), length)
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	err = compressor.Close()
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	_, err = seeker.Seek(start, io.SeekStart)
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	if counter.n > length-length/minCompressionSaving {
+		return ioutil.NopCloser(io.LimitReader(r, length)), length, CompressionNone, nil
+	}
+
+	// Compress While Reading
+	pr, pw := io.Pipe()
+	go func() {
+		compressor, err := newCompressor(pw, compression)
+		if err == nil {
+			_, err = io.CopyN(compressor, seeker, length)
+		}
+		if err == nil {
+			err = compressor.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return pr, counter.n, compression, nil
+}
@@ -0,0 +1,303 @@
+package core
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// DefaultDataShards is the number of blocks in each erasure coded group
+// when only the number of parity shards is set
+const DefaultDataShards = 10
+
+// gfExp and gfLog are the exponent and logarithm tables of GF(2^8) with the
+// polynomial x^8 + x^4 + x^3 + x^2 + 1
+var gfExp, gfLog = gfTables()
+
+func gfTables() ([510]byte, [256]byte) {
+	var exp [510]byte
+	var log [256]byte
+
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(x)
+		exp[i+255] = byte(x)
+		log[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+
+	return exp, log
+}
+
+func gfMul(a byte, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// Erasure encodes groups of equally sized data shards with Reed-Solomon
+// parity shards, so a group can be rebuilt from any of its data shards
+type Erasure struct {
+	data   int
+	parity int
+	matrix [][]byte
+}
+
+// NewErasure returns an erasure code with data and parity shards per group
+func NewErasure(data int, parity int) (*Erasure, error) {
+	if data < 1 || parity < 1 || data+parity > 256 {
+		return nil, fmt.Errorf("Erasure code needs 1 or more data and parity shards, 256 at most")
+	}
+
+	// Systematic Matrix, Identity over Cauchy Rows
+	matrix := make([][]byte, data+parity)
+	for i := range matrix {
+		matrix[i] = make([]byte, data)
+		for j := 0; j < data; j++ {
+			if i < data {
+				if i == j {
+					matrix[i][j] = 1
+				}
+			} else {
+				matrix[i][j] = gfInv(byte(i) ^ byte(j))
+			}
+		}
+	}
+
+	return &Erasure{data: data, parity: parity, matrix: matrix}, nil
+}
+
+// Encode returns the parity shards of a group of data shards. Missing data
+// shards at the end of a short group are nil and count as zeros.
+func (e *Erasure) Encode(shards [][]byte) ([][]byte, error) {
+	if len(shards) != e.data {
+		return nil, fmt.Errorf("Erasure code expected %d data shards, got %d", e.data, len(shards))
+	}
+
+	size := shardSize(shards)
+	parity := make([][]byte, e.parity)
+	for i := range parity {
+		parity[i] = make([]byte, size)
+		for j, shard := range shards {
+			mulAdd(parity[i], shard, e.matrix[e.data+i][j])
+		}
+	}
+
+	return parity, nil
+}
+
+// Reconstruct fills the missing shards of a group of data followed by
+// parity shards, which are nil when missing. At least as many shards as
+// there are data shards must be present.
+func (e *Erasure) Reconstruct(shards [][]byte) error {
+	if len(shards) != e.data+e.parity {
+		return fmt.Errorf("Erasure code expected %d shards, got %d", e.data+e.parity, len(shards))
+	}
+
+	// Choose Present Shards
+	rows := []int{}
+	for i, shard := range shards {
+		if shard != nil && len(rows) < e.data {
+			rows = append(rows, i)
+		}
+	}
+
+	if len(rows) < e.data {
+		return fmt.Errorf("Erasure code needs %d shards, %d present", e.data, len(rows))
+	}
+
+	size := shardSize(shards)
+
+	// Invert Rows of Present Shards
+	matrix := make([][]byte, e.data)
+	for i, row := range rows {
+		matrix[i] = e.matrix[row]
+	}
+
+	inverse, err := gfInvert(matrix)
+	if err != nil {
+		return err
+	}
+
+	// Rebuild Data Shards
+	for i := 0; i < e.data; i++ {
+		if shards[i] != nil {
+			continue
+		}
+		shards[i] = make([]byte, size)
+		for j, row := range rows {
+			mulAdd(shards[i], shards[row], inverse[i][j])
+		}
+	}
+
+	// Rebuild Parity Shards
+	for i := e.data; i < len(shards); i++ {
+		if shards[i] != nil {
+			continue
+		}
+		shards[i] = make([]byte, size)
+		for j := 0; j < e.data; j++ {
+			mulAdd(shards[i], shards[j], e.matrix[i][j])
+		}
+	}
+
+	return nil
+}
+
+func shardSize(shards [][]byte) int {
+	size := 0
+	for _, shard := range shards {
+		if len(shard) > size {
+			size = len(shard)
+		}
+	}
+	return size
+}
+
+// mulAdd adds src multiplied by c to dst, treating src as zero padded
+func mulAdd(dst []byte, src []byte, c byte) {
+	if c == 0 {
+		return
+	}
+	for i, b := range src {
+		dst[i] ^= gfMul(b, c)
+	}
+}
+
+// gfInvert returns the inverse of a square matrix with Gauss-Jordan
+// elimination
+func gfInvert(matrix [][]byte) ([][]byte, error) {
+	n := len(matrix)
+	work := make([][]byte, n)
+	for i := range work {
+		work[i] = make([]byte, 2*n)
+		copy(work[i], matrix[i])
+		work[i][n+i] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && work[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, fmt.Errorf("Erasure code matrix is singular")
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+
+		scale := gfInv(work[col][col])
+		for j := range work[col] {
+			work[col][j] = gfMul(work[col][j], scale)
+		}
+
+		for row := 0; row < n; row++ {
+			if row != col && work[row][col] != 0 {
+				factor := work[row][col]
+				for j := range work[row] {
+					work[row][j] ^= gfMul(work[col][j], factor)
+				}
+			}
+		}
+	}
+
+	inverse := make([][]byte, n)
+	for i := range inverse {
+		inverse[i] = work[i][n:]
+	}
+
+	return inverse, nil
+}
+
+// ParityID returns the id of a parity block of a group of blocks
+func ParityID(fileID string, group int, index int) string {
+	hash := sha3.New256()
+	hash.Write([]byte(fileID))
+	hash.Write([]byte("parity"))
+	hash.Write([]byte(strconv.Itoa(group)))
+	hash.Write([]byte(":"))
+	hash.Write([]byte(strconv.Itoa(index)))
+	sum := hash.Sum(nil)
+	return hex.EncodeToString(sum)
+}
+
+// withParity returns fn, followed by the parity blocks of each group if the
+// layout has parity shards
+func (l Layout) withParity(fileID string, count int, fn func(EncryptedBlock) error) (func(EncryptedBlock) error, error) {
+	if l.ParityShards == 0 {
+		return fn, nil
+	}
+
+	w, err := newParityWriter(fileID, count, l.DataShards, l.ParityShards, fn)
+	if err != nil {
+		return nil, err
+	}
+	return w.Write, nil
+}
+
+// parityWriter passes encrypted blocks on to fn, followed by the parity
+// blocks of each group once it is complete
+type parityWriter struct {
+	erasure *Erasure
+	fileID  string
+	count   int
+	group   [][]byte
+	written int
+	fn      func(EncryptedBlock) error
+}
+
+func newParityWriter(fileID string, count int, data int, parity int, fn func(EncryptedBlock) error) (*parityWriter, error) {
+	erasure, err := NewErasure(data, parity)
+	if err != nil {
+		return nil, err
+	}
+
+	return &parityWriter{
+		erasure: erasure,
+		fileID:  fileID,
+		count:   count,
+		fn:      fn,
+	}, nil
+}
+
+func (w *parityWriter) Write(block EncryptedBlock) error {
+	err := w.fn(block)
+	if err != nil {
+		return err
+	}
+
+	w.group = append(w.group, block.Data)
+	w.written++
+	if len(w.group) < w.erasure.data && w.written < w.count {
+		return nil
+	}
+
+	// Encode Group, Short Groups Padded with Empty Shards
+	shards := make([][]byte, w.erasure.data)
+	copy(shards, w.group)
+	w.group = w.group[:0]
+
+	parity, err := w.erasure.Encode(shards)
+	if err != nil {
+		return err
+	}
+
+	group := (w.written - 1) / w.erasure.data
+	for i, data := range parity {
+		err = w.fn(EncryptedBlock{ID: ParityID(w.fileID, group, i), Data: data})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
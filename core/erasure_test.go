@@ -0,0 +1,96 @@
+package core
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestErasureReconstruct(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	for _, shards := range [][2]int{{1, 1}, {3, 2}, {10, 4}, {200, 56}} {
+		erasure, err := NewErasure(shards[0], shards[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data := make([][]byte, shards[0])
+		for i := range data {
+			data[i] = make([]byte, 50)
+			random.Read(data[i])
+		}
+
+		parity, err := erasure.Encode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Any Shards Up to the Parity Count Can be Lost
+		for trial := 0; trial < 10; trial++ {
+			group := append(append([][]byte{}, data...), parity...)
+			for _, i := range random.Perm(len(group))[:shards[1]] {
+				group[i] = nil
+			}
+
+			err = erasure.Reconstruct(group)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, shard := range append(append([][]byte{}, data...), parity...) {
+				if !bytes.Equal(group[i], shard) {
+					t.Fatalf("%d+%d: shard %d not rebuilt", shards[0], shards[1], i)
+				}
+			}
+		}
+
+		// But No More
+		group := append(append([][]byte{}, data...), parity...)
+		for i := 0; i <= shards[1]; i++ {
+			group[i] = nil
+		}
+		if err := erasure.Reconstruct(group); err == nil {
+			t.Fatalf("%d+%d: rebuilt with %d shards missing", shards[0], shards[1], shards[1]+1)
+		}
+	}
+}
+
+func TestErasureShortGroup(t *testing.T) {
+	erasure, err := NewErasure(4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Missing Data Shards at the End Count as Zeros
+	data := [][]byte{[]byte("first"), []byte("second"), nil, nil}
+	parity, err := erasure.Encode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group := [][]byte{nil, []byte("second"), make([]byte, 6), make([]byte, 6), parity[0], parity[1]}
+	err = erasure.Reconstruct(group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bytes.TrimRight(group[0], "\x00"), []byte("first")) {
+		t.Fatalf("Rebuilt shard = %q, expected %q", group[0], "first")
+	}
+}
+
+func TestErasureInvalid(t *testing.T) {
+	for _, shards := range [][2]int{{0, 1}, {1, 0}, {200, 57}} {
+		if _, err := NewErasure(shards[0], shards[1]); err == nil {
+			t.Errorf("NewErasure(%d, %d) succeeded", shards[0], shards[1])
+		}
+	}
+
+	erasure, _ := NewErasure(2, 1)
+	if _, err := erasure.Encode([][]byte{{1}}); err == nil {
+		t.Error("Encode of too few shards succeeded")
+	}
+	if err := erasure.Reconstruct([][]byte{{1}, {2}}); err == nil {
+		t.Error("Reconstruct of too few shards succeeded")
+	}
+}
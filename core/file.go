@@ -1,8 +1,15 @@
 package core
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"time"
 
 	"github.com/decred/dcrd/hdkeychain/v3"
 )
@@ -16,18 +23,162 @@ type File struct {
 }
 
 // Meta ...
+//
+// Fields after Tags are optional, so metadata written before they were added
+// still parses. Size and SHA256 describe the plaintext of files and are set
+// when a file is created.
 type Meta struct {
-	Name string   `json:"Name"`
-	Type string   `json:"Type"`
-	Tags []string `json:"Tags"`
+	Name       string            `json:"Name"`
+	Type       string            `json:"Type"`
+	Tags       []string          `json:"Tags"`
+	Size       int64             `json:"Size,omitempty"`
+	Created    *time.Time        `json:"Created,omitempty"`
+	Modified   *time.Time        `json:"Modified,omitempty"`
+	Mode       uint32            `json:"Mode,omitempty"`
+	Owner      string            `json:"Owner,omitempty"`
+	MIME       string            `json:"MIME,omitempty"`
+	SHA256     string            `json:"SHA256,omitempty"`
+	Attributes map[string]string `json:"Attributes,omitempty"`
+}
+
+// Options configure how file data is stored
+type Options struct {
+	// Compression compresses file data before it is split into blocks. It is
+	// skipped for data that does not compress or is not an io.ReadSeeker,
+	// as compressed data is measured before it is stored.
+	Compression string
+	// Chunking selects how file data is split into blocks. Content-defined
+	// chunks are not compressed.
+	Chunking string
+	// ParityShards adds Reed-Solomon parity blocks to each group of
+	// DataShards meta and file blocks, so a group can be rebuilt from any
+	// DataShards of its blocks. Shared chunks are not covered.
+	DataShards   int
+	ParityShards int
+	// Padding adds blocks to hide the size of the meta and file data
+	Padding       string
+	PaddingBlocks int
 }
 
 // CreateFile returns a file object
 func CreateFile(parent *hdkeychain.ExtendedKey, index uint32, meta Meta, data []byte, size int, version uint32) (File, error) {
+	var fileBlocks []EncryptedBlock
+	file, err := CreateFileFromReader(parent, index, meta, bytes.NewReader(data), int64(len(data)), size, version, Options{}, func(block EncryptedBlock) error {
+		fileBlocks = append(fileBlocks, block)
+		return nil
+	})
+	if err != nil {
+		return File{}, err
+	}
+
+	file.FileBlocks = fileBlocks
+	return file, nil
+}
+
+// CreateFileFromReader returns a file object without file blocks, passing
+// each encrypted file block read from r to fn as it is created
+func CreateFileFromReader(parent *hdkeychain.ExtendedKey, index uint32, meta Meta, r io.Reader, length int64, size int, version uint32, options Options, fn func(EncryptedBlock) error) (File, error) {
+	if options.Chunking != ChunkingFixed {
+		return File{}, fmt.Errorf("Chunking %q requires CreateChunkedFile", options.Chunking)
+	}
+
+	// Compress File Data
+	digest := sha256.New()
+	data, stored, compression, err := compressReader(r, length, options.Compression, digest)
+	if err != nil {
+		return File{}, err
+	}
+	defer data.Close()
+
+	file, err := createFile(parent, index, meta, data, stored, size, version, options, Layout{
+		Size:        length,
+		Compression: compression,
+	}, digest, fn)
+	if err != nil {
+		return File{}, err
+	}
+
+	// Compressed Data Must Match Measured Length
+	if n, _ := data.Read(make([]byte, 1)); n > 0 {
+		return File{}, fmt.Errorf("File data changed while compressing")
+	}
+
+	return file, nil
+}
+
+// CreateChunkedFile returns a file object whose file blocks store the
+// manifest of the content-defined chunks read from r, passing each chunk to
+// fn as it is created. Chunks repeated within r are passed each time.
+func CreateChunkedFile(parent *hdkeychain.ExtendedKey, index uint32, meta Meta, r io.Reader, size int, version uint32, options Options, keys *ChunkKeys, fn func(ChunkRef, EncryptedBlock) error) (File, Manifest, error) {
+	if size <= 0 {
+		return File{}, Manifest{}, fmt.Errorf("Block size must be greater than 0")
+	}
+
+	// Create Chunks
+	digest := sha256.New()
+	manifest := Manifest{Chunks: []ChunkRef{}}
+	chunker := keys.NewChunker(io.TeeReader(r, digest), size)
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return File{}, Manifest{}, err
+		}
+
+		ref, block, err := keys.EncryptChunk(chunk, size)
+		if err != nil {
+			return File{}, Manifest{}, err
+		}
+
+		err = fn(ref, block)
+		if err != nil {
+			return File{}, Manifest{}, err
+		}
+		manifest.Chunks = append(manifest.Chunks, ref)
+	}
+
+	// Store Manifest as File Data
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return File{}, Manifest{}, err
+	}
+
+	var fileBlocks []EncryptedBlock
+	file, err := createFile(parent, index, meta, bytes.NewReader(data), int64(len(data)), size, version, options, Layout{
+		Size:     manifest.Size(),
+		Chunking: ChunkingContent,
+	}, digest, func(block EncryptedBlock) error {
+		fileBlocks = append(fileBlocks, block)
+		return nil
+	})
+	if err != nil {
+		return File{}, Manifest{}, err
+	}
+
+	file.FileBlocks = fileBlocks
+	return file, manifest, nil
+}
+
+// createFile returns a file object with a signed layout, passing the
+// encrypted file blocks of length bytes read from r to fn. The meta of files
+// gets the plaintext size and the SHA-256 from digest once r is read.
+func createFile(parent *hdkeychain.ExtendedKey, index uint32, meta Meta, r io.Reader, length int64, size int, version uint32, options Options, layout Layout, digest hash.Hash, fn func(EncryptedBlock) error) (File, error) {
 	if index < 1 {
 		return File{}, fmt.Errorf("Index must be greater than 0")
 	}
 
+	if size <= 0 {
+		return File{}, fmt.Errorf("Block size must be greater than 0")
+	}
+
+	layout.DataShards = options.DataShards
+	layout.ParityShards = options.ParityShards
+	if layout.ParityShards > 0 && layout.DataShards == 0 {
+		layout.DataShards = DefaultDataShards
+	}
+
 	fileKey, err := parent.Child(index)
 	if err != nil {
 		return File{}, err
@@ -41,10 +192,86 @@ func CreateFile(parent *hdkeychain.ExtendedKey, index uint32, meta Meta, data []
 
 	publicKey, err := keyFile.PublicKey()
 	if err != nil {
-		return File{}, nil
+		return File{}, err
+	}
+
+	// Block Layout
+	layout.FileBlocks, err = options.paddedCount(BlockCount(length, size))
+	if err != nil {
+		return File{}, err
+	}
+	layout.Stored = length
+	layout.Padding = options.Padding
+
+	// Create File Blocks, Hashing Each for the Merkle Tree
+	fileID := FileID(publicKey, keyFile.FileSalt)
+	parityFn, err := layout.withParity(fileID, layout.FileBlocks, fn)
+	if err != nil {
+		return File{}, err
+	}
+
+	leaves := make([][]byte, 0, layout.FileBlocks)
+	err = createEncryptedBlocks(fileID, keyFile.FileKey(), r, length, size, layout.FileBlocks, func(block EncryptedBlock) error {
+		leaves = append(leaves, MerkleLeaf(block.Data))
+		return parityFn(block)
+	})
+	if err != nil {
+		return File{}, err
+	}
+
+	// Create Tree Blocks Storing the Leaf Hashes
+	tree := bytes.Join(leaves, nil)
+	treeID := TreeID(fileID)
+	layout.Root = MerkleRoot(leaves)
+	layout.TreeBlocks = BlockCount(int64(len(tree)), size)
+
+	treeFn, err := layout.withParity(treeID, layout.TreeBlocks, fn)
+	if err != nil {
+		return File{}, err
+	}
+
+	err = createEncryptedBlocks(treeID, keyFile.FileKey(), bytes.NewReader(tree), int64(len(tree)), size, layout.TreeBlocks, treeFn)
+	if err != nil {
+		return File{}, err
+	}
+
+	// Describe File Contents
+	if digest != nil && meta.Type != "" {
+		meta.Size = layout.Size
+		meta.SHA256 = hex.EncodeToString(digest.Sum(nil))
 	}
 
 	// Create Meta Blocks
+	metaBlocks, err := createMetaBlocks(&keyFile, meta, size, options, &layout)
+	if err != nil {
+		return File{}, err
+	}
+
+	// Sign Block Layout
+	err = keyFile.SetLayout(layout)
+	if err != nil {
+		return File{}, err
+	}
+
+	err = keyFile.Sign()
+	if err != nil {
+		return File{}, err
+	}
+
+	return File{
+		Key:        keyFile.file,
+		KeyFile:    keyFile,
+		MetaBlocks: metaBlocks,
+	}, nil
+}
+
+// createMetaBlocks returns the encrypted meta blocks of a key file, setting
+// their number in layout
+func createMetaBlocks(keyFile *KeyFile, meta Meta, size int, options Options, layout *Layout) ([]EncryptedBlock, error) {
+	publicKey, err := keyFile.PublicKey()
+	if err != nil {
+		return nil, err
+	}
 	metaID := FileID(publicKey, keyFile.MetaSalt)
 
 	var metaData []byte
@@ -53,18 +280,98 @@ func CreateFile(parent *hdkeychain.ExtendedKey, index uint32, meta Meta, data []
 	} else {
 		metaData, err = json.Marshal(meta)
 		if err != nil {
-			return File{}, err
+			return nil, err
 		}
 	}
 
-	encryptedMetaBlocks, err := CreateEncryptedBlocks(metaID, keyFile.Key(), metaData, size)
+	layout.MetaBlocks, err = options.paddedCount(BlockCount(int64(len(metaData)), size))
+	if err != nil {
+		return nil, err
+	}
+
+	var encryptedMetaBlocks []EncryptedBlock
+	metaFn, err := layout.withParity(metaID, layout.MetaBlocks, func(block EncryptedBlock) error {
+		encryptedMetaBlocks = append(encryptedMetaBlocks, block)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = createEncryptedBlocks(metaID, keyFile.MetaKey(), bytes.NewReader(metaData), int64(len(metaData)), size, layout.MetaBlocks, metaFn)
+	if err != nil {
+		return nil, err
+	}
+
+	return encryptedMetaBlocks, nil
+}
+
+// createUnboundMetaBlocks returns meta blocks for key files before
+// FormatBound, which readers count from the first block
+func createUnboundMetaBlocks(keyFile *KeyFile, meta Meta, size int) ([]EncryptedBlock, error) {
+	publicKey, err := keyFile.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	metaData, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := CreateBlocks(FileID(publicKey, keyFile.MetaSalt), metaData, size)
+	encryptedBlocks := make([]EncryptedBlock, len(blocks))
+	for i, block := range blocks {
+		encryptedBlocks[i], err = block.Encrypt(keyFile.MetaKey())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return encryptedBlocks, nil
+}
+
+// ReviseFile returns the next version of a file with new meta blocks. The
+// file blocks are kept, as the shared secret and file salt are unchanged,
+// and the meta blocks are padded like the file blocks. Key files before
+// FormatBound keep their format and get unbound meta blocks.
+func ReviseFile(keyFile KeyFile, meta Meta, size int, options Options) (File, error) {
+	if size <= 0 {
+		return File{}, fmt.Errorf("Block size must be greater than 0")
+	}
+
+	version, err := keyFile.GetVersion()
 	if err != nil {
 		return File{}, err
 	}
 
-	// Create File Blocks
-	fileID := FileID(publicKey, keyFile.FileSalt)
-	encryptedFileBlocks, err := CreateEncryptedBlocks(fileID, keyFile.Key(), data, size)
+	layout, err := keyFile.GetLayout()
+	if err != nil {
+		return File{}, err
+	}
+	options.Padding = layout.Padding
+
+	// New Version and Meta Salt
+	keyFile.Version = []byte(strconv.Itoa(int(version + 1)))
+	keyFile.MetaSalt, err = RandomBytes(16)
+	if err != nil {
+		return File{}, err
+	}
+
+	var metaBlocks []EncryptedBlock
+	if keyFile.Format < FormatBound {
+		metaBlocks, err = createUnboundMetaBlocks(&keyFile, meta, size)
+	} else {
+		metaBlocks, err = createMetaBlocks(&keyFile, meta, size, options, &layout)
+		if err == nil {
+			err = keyFile.SetLayout(layout)
+		}
+	}
+	if err != nil {
+		return File{}, err
+	}
+
+	err = keyFile.Sign()
 	if err != nil {
 		return File{}, err
 	}
@@ -72,14 +379,68 @@ func CreateFile(parent *hdkeychain.ExtendedKey, index uint32, meta Meta, data []
 	return File{
 		Key:        keyFile.file,
 		KeyFile:    keyFile,
-		MetaBlocks: encryptedMetaBlocks,
-		FileBlocks: encryptedFileBlocks,
+		MetaBlocks: metaBlocks,
 	}, nil
 }
 
 // CreateFolder ...
 func CreateFolder(parent *hdkeychain.ExtendedKey, index uint32, meta Meta, size int) (File, error) {
-	return CreateFile(parent, index, meta, []byte{}, size, 0)
+	return CreateFolderWithOptions(parent, index, meta, size, 0, Options{})
+}
+
+// CreateFolderWithOptions returns a folder with padding and parity blocks
+// from options
+func CreateFolderWithOptions(parent *hdkeychain.ExtendedKey, index uint32, meta Meta, size int, version uint32, options Options) (File, error) {
+	options.Compression = CompressionNone
+	options.Chunking = ChunkingFixed
+
+	var fileBlocks []EncryptedBlock
+	file, err := createFile(parent, index, meta, bytes.NewReader(nil), 0, size, version, options, Layout{}, nil, func(block EncryptedBlock) error {
+		fileBlocks = append(fileBlocks, block)
+		return nil
+	})
+	if err != nil {
+		return File{}, err
+	}
+
+	file.FileBlocks = fileBlocks
+	return file, nil
+}
+
+// CreateTombstone returns the key file of a removed file, which replaces the
+// key file of the previous version
+func CreateTombstone(parent *hdkeychain.ExtendedKey, index uint32, version uint32) (File, error) {
+	// No Blocks, so Any Block Size
+	return createFile(parent, index, Meta{}, bytes.NewReader(nil), 0, 1, version, Options{}, Layout{Removed: true}, nil, func(EncryptedBlock) error {
+		return nil
+	})
+}
+
+// ReviseLayout returns the next version of a key file with layout, keeping
+// its salts so its blocks are kept. Formats before FormatBound have no
+// layout.
+func ReviseLayout(keyFile KeyFile, layout Layout) (KeyFile, error) {
+	if keyFile.Format < FormatBound {
+		return KeyFile{}, fmt.Errorf("Key files before format %d have no layout", FormatBound)
+	}
+
+	version, err := keyFile.GetVersion()
+	if err != nil {
+		return KeyFile{}, err
+	}
+
+	keyFile.Version = []byte(strconv.Itoa(int(version + 1)))
+	err = keyFile.SetLayout(layout)
+	if err != nil {
+		return KeyFile{}, err
+	}
+
+	err = keyFile.Sign()
+	if err != nil {
+		return KeyFile{}, err
+	}
+
+	return keyFile, nil
 }
 
 // RecreateFile ...
@@ -0,0 +1,172 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/rand"
+	"testing"
+)
+
+// storedFile parses the stored key file of a file, as a reader would
+func storedFile(t *testing.T, file File) KeyFile {
+	encrypted := file.KeyFile.Encrypt()
+	data, err := encrypted.Serialise()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyFile, err := ParseKeyFile(file.Key, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyFile.Check(); err != nil {
+		t.Fatal(err)
+	}
+	return keyFile
+}
+
+// readBlocks decrypts count bound blocks of the set with id
+func readBlocks(t *testing.T, blocks map[string][]byte, id string, count int, key []byte) ([]byte, [][]byte) {
+	var data []byte
+	var leaves [][]byte
+	for i := 0; i < count; i++ {
+		blockID := BlockID(id, i)
+		encrypted, ok := blocks[blockID]
+		if !ok {
+			t.Fatalf("Block %d of %d missing", i, count)
+		}
+
+		block, err := EncryptedBlock{ID: blockID, Data: encrypted}.DecryptWithAD(key, BlockAD(id, blockID, i, count))
+		if err != nil {
+			t.Fatalf("Block %d of %d: %v", i, count, err)
+		}
+		data = append(data, block.Data...)
+		leaves = append(leaves, MerkleLeaf(encrypted))
+	}
+	return data, leaves
+}
+
+func TestFileRoundTrip(t *testing.T) {
+	data := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	options := Options{ParityShards: 2, DataShards: 4, Padding: PaddingPowerOfTwo}
+	blocks := map[string][]byte{}
+	file, err := CreateFileFromReader(testFileKey(t, 0), 1, Meta{Name: "file", Type: "file"}, bytes.NewReader(data), int64(len(data)), 64, 0, options, func(block EncryptedBlock) error {
+		blocks[block.ID] = block.Data
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range file.MetaBlocks {
+		blocks[block.ID] = block.Data
+	}
+
+	keyFile := storedFile(t, file)
+	layout, err := keyFile.GetLayout()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layout.FileBlocks != 16 || layout.Size != int64(len(data)) || layout.ParityShards != 2 {
+		t.Fatalf("Layout = %+v", layout)
+	}
+
+	publicKey, err := keyFile.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// File Blocks, Padded and Covered by the Merkle Root
+	fileID := FileID(publicKey, keyFile.FileSalt)
+	stored, leaves := readBlocks(t, blocks, fileID, layout.FileBlocks, keyFile.FileKey())
+	if !bytes.Equal(stored, data) {
+		t.Fatal("File data differs")
+	}
+	if !bytes.Equal(MerkleRoot(leaves), layout.Root) {
+		t.Fatal("Merkle root differs")
+	}
+
+	tree, _ := readBlocks(t, blocks, TreeID(fileID), layout.TreeBlocks, keyFile.FileKey())
+	if !bytes.Equal(tree, bytes.Join(leaves, nil)) {
+		t.Fatal("Tree blocks differ from the leaf hashes")
+	}
+
+	// Parity Blocks for Each Group
+	for group := 0; group < layout.FileBlocks/layout.DataShards; group++ {
+		for i := 0; i < layout.ParityShards; i++ {
+			if _, ok := blocks[ParityID(fileID, group, i)]; !ok {
+				t.Fatalf("Parity block %d of group %d missing", i, group)
+			}
+		}
+	}
+
+	// Meta Describing the Data
+	metaData, _ := readBlocks(t, blocks, FileID(publicKey, keyFile.MetaSalt), layout.MetaBlocks, keyFile.MetaKey())
+	meta, err := ParseMeta(metaData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if meta.Name != "file" || meta.Size != int64(len(data)) || meta.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("Meta = %+v", meta)
+	}
+}
+
+func TestChunkedFileRoundTrip(t *testing.T) {
+	data := make([]byte, 5000)
+	rand.New(rand.NewSource(1)).Read(data)
+	data = append(data, data...)
+
+	keys := testChunkKeys(t)
+	chunks := map[string]EncryptedBlock{}
+	file, manifest, err := CreateChunkedFile(testFileKey(t, 0), 1, Meta{Name: "file", Type: "file"}, bytes.NewReader(data), 1024, 0, Options{Chunking: ChunkingContent}, keys, func(ref ChunkRef, block EncryptedBlock) error {
+		chunks[ref.ID] = block
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) >= len(manifest.Chunks) {
+		t.Fatalf("Repeated data stored %d chunks for %d references", len(chunks), len(manifest.Chunks))
+	}
+
+	blocks := map[string][]byte{}
+	for _, block := range file.FileBlocks {
+		blocks[block.ID] = block.Data
+	}
+
+	keyFile := storedFile(t, file)
+	layout, err := keyFile.GetLayout()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layout.Chunking != ChunkingContent || layout.Size != int64(len(data)) {
+		t.Fatalf("Layout = %+v", layout)
+	}
+
+	publicKey, err := keyFile.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// File Blocks Store the Manifest
+	stored, _ := readBlocks(t, blocks, FileID(publicKey, keyFile.FileSalt), layout.FileBlocks, keyFile.FileKey())
+	parsed, err := ParseManifest(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var joined []byte
+	for _, ref := range parsed.Chunks {
+		chunk, err := ref.Decrypt(chunks[ref.ID])
+		if err != nil {
+			t.Fatal(err)
+		}
+		joined = append(joined, chunk...)
+	}
+	if !bytes.Equal(joined, data) {
+		t.Fatal("Chunked file data differs")
+	}
+}
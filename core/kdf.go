@@ -0,0 +1,71 @@
+package core
+
+import (
+	"crypto/sha256"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Key file formats
+const (
+	// FormatLegacy uses the shared secret as the key for key file fields,
+	// meta blocks and file blocks
+	FormatLegacy = 0
+	// FormatHKDF derives a separate key for key file fields, meta blocks and
+	// file blocks from the shared secret with HKDF-SHA256
+	FormatHKDF = 1
+	// FormatBound adds the block counts to the key file and binds each block
+	// to its file, position and count as additional data
+	FormatBound = 2
+	// CurrentFormat is used for new key files
+	CurrentFormat = FormatBound
+)
+
+// Key schedule labels
+const (
+	keyFileInfo = "whitebox/v1/key-file"
+	metaInfo    = "whitebox/v1/meta"
+	fileInfo    = "whitebox/v1/file"
+
+	chunkIDInfo   = "whitebox/v1/chunk-id"
+	chunkKeyInfo  = "whitebox/v1/chunk-key"
+	chunkRefInfo  = "whitebox/v1/chunk-ref"
+	chunkGearInfo = "whitebox/v1/chunk-gear"
+)
+
+// DeriveKey returns a 256 bit key derived from a secret with HKDF-SHA256
+func DeriveKey(secret []byte, salt []byte, info string) []byte {
+	key := make([]byte, 32)
+	_, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key)
+	if err != nil {
+		// HKDF only fails when reading more than 255 hashes
+		panic(err)
+	}
+	return key
+}
+
+// fieldKey returns the key for the encrypted key file fields, salted with
+// the ephemeral public key as the other salts are encrypted with it
+func (f *KeyFile) fieldKey() []byte {
+	if f.Format == FormatLegacy {
+		return f.key
+	}
+	return DeriveKey(f.key, f.EphemKey, keyFileInfo)
+}
+
+// MetaKey returns the key for the meta blocks
+func (f *KeyFile) MetaKey() []byte {
+	if f.Format == FormatLegacy {
+		return f.key
+	}
+	return DeriveKey(f.key, f.MetaSalt, metaInfo)
+}
+
+// FileKey returns the key for the file blocks
+func (f *KeyFile) FileKey() []byte {
+	if f.Format == FormatLegacy {
+		return f.key
+	}
+	return DeriveKey(f.key, f.FileSalt, fileInfo)
+}
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/decred/dcrd/hdkeychain/v3"
+)
+
+const testMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+func testFileKey(t *testing.T, index uint32) *hdkeychain.ExtendedKey {
+	_, root, err := GetRootFolder(testMnemonic, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := root.Child(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// testKeyFile returns a signed key file of format, stored and parsed again
+func testKeyFile(t *testing.T, hdkey *hdkeychain.ExtendedKey, format int) (KeyFile, []byte) {
+	keyFile, err := CreateKeyFile(hdkey, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyFile.Format = format
+	if format >= FormatBound {
+		err = keyFile.SetLayout(Layout{MetaBlocks: 1, FileBlocks: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = keyFile.Sign()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted := keyFile.Encrypt()
+	data, err := encrypted.Serialise()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyFile, data
+}
+
+func TestKeyFileFormats(t *testing.T) {
+	hdkey := testFileKey(t, 1)
+
+	for _, format := range []int{FormatLegacy, FormatHKDF, FormatBound} {
+		keyFile, data := testKeyFile(t, hdkey, format)
+
+		parsed, err := ParseKeyFile(hdkey, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := parsed.Check(); err != nil {
+			t.Fatalf("Format %d: %v", format, err)
+		}
+
+		if parsed.Format != format || !bytes.Equal(parsed.MetaSalt, keyFile.MetaSalt) || !bytes.Equal(parsed.FileSalt, keyFile.FileSalt) || !bytes.Equal(parsed.Layout, keyFile.Layout) {
+			t.Fatalf("Format %d: parsed key file differs", format)
+		}
+
+		// Legacy Key Files Use the Shared Secret for Everything
+		metaKey, fileKey := parsed.MetaKey(), parsed.FileKey()
+		if format == FormatLegacy {
+			if !bytes.Equal(metaKey, parsed.Key()) || !bytes.Equal(fileKey, parsed.Key()) {
+				t.Fatal("Legacy key file derived keys")
+			}
+			continue
+		}
+
+		if bytes.Equal(metaKey, parsed.Key()) || bytes.Equal(fileKey, parsed.Key()) || bytes.Equal(metaKey, fileKey) || bytes.Equal(parsed.fieldKey(), metaKey) {
+			t.Fatalf("Format %d: keys are not separate", format)
+		}
+		if !bytes.Equal(metaKey, DeriveKey(parsed.Key(), parsed.MetaSalt, metaInfo)) {
+			t.Fatalf("Format %d: meta key is not derived from the meta salt", format)
+		}
+	}
+}
+
+func TestKeyFileFormatTampered(t *testing.T) {
+	hdkey := testFileKey(t, 1)
+
+	for _, formats := range [][2]int{{FormatLegacy, FormatHKDF}, {FormatHKDF, FormatLegacy}, {FormatHKDF, FormatBound}, {FormatBound, FormatHKDF}} {
+		_, data := testKeyFile(t, hdkey, formats[0])
+
+		var stored map[string]interface{}
+		err := json.Unmarshal(data, &stored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored["Format"] = formats[1]
+		data, err = json.Marshal(stored)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parsed, err := ParseKeyFile(hdkey, data)
+		if err != nil {
+			continue
+		}
+		if err := parsed.Check(); err == nil {
+			t.Errorf("Key file of format %d verified as format %d", formats[0], formats[1])
+		}
+	}
+}
+
+func TestKeyFileProof(t *testing.T) {
+	hdkey := testFileKey(t, 1)
+	_, data := testKeyFile(t, hdkey, FormatBound)
+
+	encrypted, err := UnmarshalKeyFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid, err := encrypted.VerifyProof()
+	if err != nil || !valid {
+		t.Fatalf("VerifyProof = %v, %v", valid, err)
+	}
+
+	encrypted.Layout[len(encrypted.Layout)-1] ^= 1
+	valid, err = encrypted.VerifyProof()
+	if err != nil || valid {
+		t.Fatalf("VerifyProof of a tampered layout = %v, %v", valid, err)
+	}
+}
@@ -1,8 +1,11 @@
 package core
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
@@ -19,7 +22,37 @@ type KeyFile struct {
 	MetaSalt  []byte
 	FileSalt  []byte
 	EphemKey  []byte
+	Format    int
+	Layout    []byte
 	Signature []byte
+	Owner     []byte
+	NextOwner []byte
+	Proof     []byte
+	ClaimKey  []byte `json:",omitempty"`
+	Claim     []byte `json:",omitempty"`
+}
+
+// Layout describes how a file is stored in blocks. Root is the Merkle root
+// of the encrypted file blocks, whose leaf hashes are stored in TreeBlocks
+// blocks with the file key. Removed marks the key file of a removed file,
+// which has no blocks, and Trashed is when a file was moved to the trash,
+// which keeps its blocks until it is purged. Revisions are the previous
+// versions of a file whose blocks are kept, newest first.
+type Layout struct {
+	MetaBlocks   int        `json:"MetaBlocks"`
+	FileBlocks   int        `json:"FileBlocks"`
+	Size         int64      `json:"Size,omitempty"`
+	Stored       int64      `json:"Stored,omitempty"`
+	Compression  string     `json:"Compression,omitempty"`
+	Chunking     string     `json:"Chunking,omitempty"`
+	DataShards   int        `json:"DataShards,omitempty"`
+	ParityShards int        `json:"ParityShards,omitempty"`
+	Padding      string     `json:"Padding,omitempty"`
+	Root         []byte     `json:"Root,omitempty"`
+	TreeBlocks   int        `json:"TreeBlocks,omitempty"`
+	Removed      bool       `json:"Removed,omitempty"`
+	Trashed      *time.Time `json:"Trashed,omitempty"`
+	Revisions    []Revision `json:"Revisions,omitempty"`
 }
 
 // MissingData returns true if fields are missing from key file
@@ -36,34 +69,202 @@ func (f *KeyFile) Encrypt() KeyFile {
 		key:       f.key,
 		file:      f.file,
 		EphemKey:  f.EphemKey,
+		Format:    f.Format,
 		Signature: f.Signature,
 	}
 
 	// Encrypt Salts
-	encryptedKeyFile.MetaSalt, _ = Encrypt(f.key, f.MetaSalt)
-	encryptedKeyFile.FileSalt, _ = Encrypt(f.key, f.FileSalt)
-	encryptedKeyFile.Version, _ = Encrypt(f.key, f.Version)
+	key := f.fieldKey()
+	encryptedKeyFile.MetaSalt, _ = Encrypt(key, f.MetaSalt)
+	encryptedKeyFile.FileSalt, _ = Encrypt(key, f.FileSalt)
+	encryptedKeyFile.Version, _ = Encrypt(key, f.Version)
+	if len(f.Layout) > 0 {
+		encryptedKeyFile.Layout, _ = Encrypt(key, f.Layout)
+	}
+
+	// Prove Ownership to Storage
+	version, err := f.GetVersion()
+	if err != nil {
+		return encryptedKeyFile
+	}
+
+	owner, err := OwnershipPublicKey(f.file, version)
+	if err != nil {
+		return encryptedKeyFile
+	}
+
+	nextOwner, err := OwnershipPublicKey(f.file, version+1)
+	if err != nil {
+		return encryptedKeyFile
+	}
+
+	ownerKey, err := OwnershipPrivateKey(f.file, version)
+	if err != nil {
+		return encryptedKeyFile
+	}
+
+	encryptedKeyFile.Owner = owner.SerializeCompressed()
+	encryptedKeyFile.NextOwner = nextOwner.SerializeCompressed()
+	encryptedKeyFile.Proof = ecdsa.Sign(ownerKey, encryptedKeyFile.proofHash()).Serialize()
 
 	return encryptedKeyFile
 }
 
+// AddClaim signs an encrypted key file with the file key if previous, the
+// encrypted key file it replaces, was written before owners, so storage
+// accepts it. Other key files are not claimed, as a claim reveals the public
+// key of the file.
+func (f *KeyFile) AddClaim(previous *KeyFile) error {
+	if len(previous.Owner) > 0 {
+		return nil
+	}
+
+	privBytes, err := f.file.SerializedPrivKey()
+	if err != nil {
+		return err
+	}
+	fileKey := secp256k1.PrivKeyFromBytes(privBytes)
+
+	f.ClaimKey = fileKey.PubKey().SerializeCompressed()
+	f.Claim = ecdsa.Sign(fileKey, f.proofHash()).Serialize()
+	return nil
+}
+
+// proofHash returns the hash of the encrypted fields signed by the owner
+func (f *KeyFile) proofHash() []byte {
+	hash := sha3.New256()
+	hash.Write(f.Version)
+	hash.Write(f.MetaSalt)
+	hash.Write(f.FileSalt)
+	hash.Write(f.EphemKey)
+	if f.Format != FormatLegacy {
+		hash.Write([]byte(strconv.Itoa(f.Format)))
+		hash.Write(f.Layout)
+	}
+	hash.Write(f.Signature)
+	hash.Write(f.Owner)
+	hash.Write(f.NextOwner)
+	return hash.Sum(nil)
+}
+
+// signatureHash returns the hash of the decrypted fields signed by the owner
+func (f *KeyFile) signatureHash() []byte {
+	hash := sha3.New256()
+	hash.Write(f.MetaSalt)
+	hash.Write(f.FileSalt)
+	hash.Write(f.Version)
+	hash.Write(f.EphemKey)
+	if f.Format != FormatLegacy {
+		hash.Write([]byte(strconv.Itoa(f.Format)))
+		hash.Write(f.Layout)
+	}
+	return hash.Sum(nil)
+}
+
+// VerifyProof returns true if an encrypted key file is signed by its owner,
+// which can be checked without any private key
+func (f *KeyFile) VerifyProof() (bool, error) {
+	owner, err := secp256k1.ParsePubKey(f.Owner)
+	if err != nil {
+		return false, err
+	}
+
+	sig, err := ecdsa.ParseDERSignature(f.Proof)
+	if err != nil {
+		return false, err
+	}
+
+	return sig.Verify(f.proofHash(), owner), nil
+}
+
+// VerifyReplacement returns true if an encrypted key file may replace the
+// previous one stored at id, which requires it to be signed by the previous
+// next owner. Key files written before owners are replaced only with a claim
+// signed by the file key, whose public key hashes to id, and only they are.
+func (f *KeyFile) VerifyReplacement(id string, previous *KeyFile) (bool, error) {
+	valid, err := f.VerifyProof()
+	if err != nil || !valid {
+		return false, err
+	}
+
+	if len(previous.Owner) == 0 {
+		return f.VerifyClaim(id)
+	}
+
+	if len(f.ClaimKey) > 0 || len(f.Claim) > 0 {
+		return false, nil
+	}
+
+	return bytes.Equal(f.Owner, previous.NextOwner), nil
+}
+
+// VerifyClaim returns true if an encrypted key file is signed by the file key
+// of the key file stored at id
+func (f *KeyFile) VerifyClaim(id string) (bool, error) {
+	if len(f.ClaimKey) == 0 || len(f.Claim) == 0 {
+		return false, nil
+	}
+
+	fileKey, err := secp256k1.ParsePubKey(f.ClaimKey)
+	if err != nil {
+		return false, err
+	}
+
+	if KeyID(fileKey) != id {
+		return false, nil
+	}
+
+	sig, err := ecdsa.ParseDERSignature(f.Claim)
+	if err != nil {
+		return false, err
+	}
+
+	return sig.Verify(f.proofHash(), fileKey), nil
+}
+
 // Decrypt returns the decrypted key file
 func (f *KeyFile) Decrypt() KeyFile {
 	decryptedKeyFile := KeyFile{
 		key:       f.key,
 		file:      f.file,
 		EphemKey:  f.EphemKey,
+		Format:    f.Format,
 		Signature: f.Signature,
 	}
 
 	// Decrypt
-	decryptedKeyFile.MetaSalt, _ = Decrypt(f.key, f.MetaSalt)
-	decryptedKeyFile.FileSalt, _ = Decrypt(f.key, f.FileSalt)
-	decryptedKeyFile.Version, _ = Decrypt(f.key, f.Version)
+	key := f.fieldKey()
+	decryptedKeyFile.MetaSalt, _ = Decrypt(key, f.MetaSalt)
+	decryptedKeyFile.FileSalt, _ = Decrypt(key, f.FileSalt)
+	decryptedKeyFile.Version, _ = Decrypt(key, f.Version)
+	if len(f.Layout) > 0 {
+		decryptedKeyFile.Layout, _ = Decrypt(key, f.Layout)
+	}
 
 	return decryptedKeyFile
 }
 
+// GetLayout returns the block layout of the key file, which is empty for
+// formats before FormatBound
+func (f *KeyFile) GetLayout() (Layout, error) {
+	var layout Layout
+	if len(f.Layout) == 0 {
+		return layout, nil
+	}
+	err := json.Unmarshal(f.Layout, &layout)
+	return layout, err
+}
+
+// SetLayout sets the block layout of the key file, which must be signed again
+func (f *KeyFile) SetLayout(layout Layout) error {
+	data, err := json.Marshal(layout)
+	if err != nil {
+		return err
+	}
+	f.Layout = data
+	return nil
+}
+
 // GetVersion returns the version of the key file
 func (f *KeyFile) GetVersion() (uint32, error) {
 	version, err := strconv.ParseInt(string(f.Version), 10, 0)
@@ -72,13 +273,6 @@ func (f *KeyFile) GetVersion() (uint32, error) {
 
 // Verify returns true if the signature is valid
 func (f *KeyFile) Verify() (bool, error) {
-	// Generate Signature
-	hash := sha3.New256()
-	hash.Write(f.MetaSalt)
-	hash.Write(f.FileSalt)
-	hash.Write(f.Version)
-	hash.Write(f.EphemKey)
-
 	sig, err := ecdsa.ParseDERSignature(f.Signature)
 	if err != nil {
 		return false, err
@@ -94,10 +288,39 @@ func (f *KeyFile) Verify() (bool, error) {
 		return false, err
 	}
 
-	return sig.Verify(hash.Sum(nil), ownerKey), nil
+	return sig.Verify(f.signatureHash(), ownerKey), nil
 }
 
-// Key returns the key file encryption key
+// SignatureError is returned when a key file signature does not verify
+type SignatureError struct {
+	KeyID string
+	Err   error
+}
+
+func (e *SignatureError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("Invalid signature for key file %s: %v", e.KeyID, e.Err)
+	}
+	return fmt.Sprintf("Invalid signature for key file %s", e.KeyID)
+}
+
+// Unwrap returns the underlying error
+func (e *SignatureError) Unwrap() error {
+	return e.Err
+}
+
+// Check returns a SignatureError if the signature is not valid
+func (f *KeyFile) Check() error {
+	valid, err := f.Verify()
+	if err == nil && valid {
+		return nil
+	}
+
+	id, _ := f.ID()
+	return &SignatureError{KeyID: id, Err: err}
+}
+
+// Key returns the shared secret the key file encryption keys derive from
 func (f *KeyFile) Key() []byte {
 	return f.key
 }
@@ -195,25 +418,46 @@ func CreateKeyFile(hdkey *hdkeychain.ExtendedKey, version uint32) (KeyFile, erro
 		FileSalt: fileSalt,
 		Version:  []byte(strconv.Itoa(int(version))),
 		EphemKey: ephemKey.PubKey().SerializeUncompressed(),
+		Format:   CurrentFormat,
 	}
 
-	// Generate Signature
-	hash := sha3.New256()
-	hash.Write(keyFile.MetaSalt)
-	hash.Write(keyFile.FileSalt)
-	hash.Write(keyFile.Version)
-	hash.Write(keyFile.EphemKey)
-
 	// Verify Owner
-	ownerKey, err := OwnershipPrivateKey(hdkey, uint32(version))
+	keyFile.Sign()
+
+	return keyFile, nil
+}
+
+// Sign signs the key file with the ownership key for its version
+func (f *KeyFile) Sign() error {
+	version, err := f.GetVersion()
 	if err != nil {
-		return keyFile, nil
+		return err
 	}
 
-	sig := ecdsa.Sign(ownerKey, hash.Sum(nil))
-	keyFile.Signature = sig.Serialize()
+	ownerKey, err := OwnershipPrivateKey(f.file, version)
+	if err != nil {
+		return err
+	}
 
-	return keyFile, nil
+	sig := ecdsa.Sign(ownerKey, f.signatureHash())
+	f.Signature = sig.Serialize()
+
+	return nil
+}
+
+// UnmarshalKeyFile returns an encrypted key file without decrypting it
+func UnmarshalKeyFile(data []byte) (KeyFile, error) {
+	var encryptedKeyFile KeyFile
+	err := json.Unmarshal(data, &encryptedKeyFile)
+	if err != nil {
+		return KeyFile{}, err
+	}
+
+	if len(encryptedKeyFile.EphemKey) == 0 {
+		return KeyFile{}, fmt.Errorf("Missing ephemeral key")
+	}
+
+	return encryptedKeyFile, nil
 }
 
 // ParseKeyFile returns a parsed key file
@@ -226,8 +470,7 @@ func ParseKeyFile(hdkey *hdkeychain.ExtendedKey, data []byte) (KeyFile, error) {
 	}
 
 	// Unmarshal Data
-	var encryptedKeyFile KeyFile
-	err = json.Unmarshal(data, &encryptedKeyFile)
+	encryptedKeyFile, err := UnmarshalKeyFile(data)
 	if err != nil {
 		return KeyFile{}, err
 	}
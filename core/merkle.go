@@ -0,0 +1,52 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// Merkle trees over encrypted file blocks follow RFC 6962, with leaves and
+// nodes hashed with distinct prefixes so a node cannot pass as a leaf.
+
+// MerkleLeaf returns the leaf hash of an encrypted block
+func MerkleLeaf(data []byte) []byte {
+	hash := sha256.New()
+	hash.Write([]byte{0})
+	hash.Write(data)
+	return hash.Sum(nil)
+}
+
+// MerkleRoot returns the root of the tree with the leaf hashes
+func MerkleRoot(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	case 1:
+		return leaves[0]
+	}
+
+	// Split at the Largest Power of Two Below the Leaf Count
+	split := 1
+	for split*2 < len(leaves) {
+		split *= 2
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte{1})
+	hash.Write(MerkleRoot(leaves[:split]))
+	hash.Write(MerkleRoot(leaves[split:]))
+	return hash.Sum(nil)
+}
+
+// TreeID returns the file id of the blocks storing the leaf hashes of the
+// file blocks of a file
+func TreeID(fileID string) string {
+	hash := sha3.New256()
+	hash.Write([]byte(fileID))
+	hash.Write([]byte("tree"))
+	sum := hash.Sum(nil)
+	return hex.EncodeToString(sum)
+}
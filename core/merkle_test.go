@@ -0,0 +1,63 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func node(left []byte, right []byte) []byte {
+	hash := sha256.New()
+	hash.Write([]byte{1})
+	hash.Write(left)
+	hash.Write(right)
+	return hash.Sum(nil)
+}
+
+func TestMerkleRoot(t *testing.T) {
+	leaves := make([][]byte, 5)
+	for i := range leaves {
+		leaves[i] = MerkleLeaf([]byte{byte(i)})
+	}
+
+	empty := sha256.Sum256(nil)
+	for i, test := range []struct {
+		leaves [][]byte
+		root   []byte
+	}{
+		{nil, empty[:]},
+		{leaves[:1], leaves[0]},
+		{leaves[:2], node(leaves[0], leaves[1])},
+		{leaves[:3], node(node(leaves[0], leaves[1]), leaves[2])},
+		{leaves[:5], node(node(node(leaves[0], leaves[1]), node(leaves[2], leaves[3])), leaves[4])},
+	} {
+		if root := MerkleRoot(test.leaves); !bytes.Equal(root, test.root) {
+			t.Errorf("Test %d: root of %d leaves = %x, expected %x", i, len(test.leaves), root, test.root)
+		}
+	}
+}
+
+func TestMerkleTampered(t *testing.T) {
+	blocks := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	leaves := make([][]byte, len(blocks))
+	for i, block := range blocks {
+		leaves[i] = MerkleLeaf(block)
+	}
+	root := MerkleRoot(leaves)
+
+	// Changed, Reordered and Dropped Blocks Change the Root
+	for name, tampered := range map[string][][]byte{
+		"changed":   {leaves[0], MerkleLeaf([]byte("x")), leaves[2]},
+		"reordered": {leaves[1], leaves[0], leaves[2]},
+		"dropped":   {leaves[0], leaves[1]},
+	} {
+		if bytes.Equal(MerkleRoot(tampered), root) {
+			t.Errorf("Root of %s leaves unchanged", name)
+		}
+	}
+
+	// A Node Cannot Pass as a Leaf
+	if bytes.Equal(MerkleLeaf(append(append([]byte{}, leaves[0]...), leaves[1]...)), MerkleRoot(leaves[:2])) {
+		t.Error("Node hashes like a leaf")
+	}
+}
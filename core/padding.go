@@ -0,0 +1,73 @@
+package core
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+)
+
+// Padding policies
+//
+// Without padding only the last block of a file is padded, so storage can
+// count blocks to learn the approximate size of each file. Padding adds
+// blocks that decrypt to no data, to the meta and file blocks alike, so
+// files of different sizes store the same number of blocks.
+const (
+	// PaddingNone stores as many blocks as the data needs
+	PaddingNone = ""
+	// PaddingPowerOfTwo rounds the number of blocks up to a power of two
+	PaddingPowerOfTwo = "pow2"
+	// PaddingBucket rounds the number of blocks up to a multiple of
+	// PaddingBlocks
+	PaddingBucket = "bucket"
+	// PaddingRandom adds up to PaddingBlocks blocks at random
+	PaddingRandom = "random"
+)
+
+// DefaultPaddingBlocks is the bucket size or largest number of random
+// blocks when PaddingBlocks is not set
+const DefaultPaddingBlocks = 8
+
+// ParsePadding returns the padding policy named none, pow2, bucket or random
+func ParsePadding(name string) (string, error) {
+	switch name {
+	case "none", PaddingNone:
+		return PaddingNone, nil
+	case PaddingPowerOfTwo, PaddingBucket, PaddingRandom:
+		return name, nil
+	default:
+		return PaddingNone, fmt.Errorf("Unknown padding %q", name)
+	}
+}
+
+// paddedCount returns the number of blocks to store count blocks of data
+func (o Options) paddedCount(count int) (int, error) {
+	blocks := o.PaddingBlocks
+	if blocks <= 0 {
+		blocks = DefaultPaddingBlocks
+	}
+
+	switch o.Padding {
+	case PaddingNone:
+		return count, nil
+	case PaddingPowerOfTwo:
+		padded := 1
+		for padded < count {
+			padded <<= 1
+		}
+		return padded, nil
+	case PaddingBucket:
+		if count == 0 {
+			return blocks, nil
+		}
+		return (count + blocks - 1) / blocks * blocks, nil
+	case PaddingRandom:
+		extra, err := rand.Int(rand.Reader, big.NewInt(int64(blocks)+1))
+		if err != nil {
+			return 0, err
+		}
+		return count + int(extra.Int64()), nil
+	default:
+		return 0, fmt.Errorf("Unknown padding %q", o.Padding)
+	}
+}
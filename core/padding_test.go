@@ -0,0 +1,36 @@
+package core
+
+import "testing"
+
+func TestPaddedCount(t *testing.T) {
+	for _, test := range []struct {
+		options Options
+		count   int
+		padded  int
+	}{
+		{Options{}, 5, 5},
+		{Options{Padding: PaddingPowerOfTwo}, 0, 1},
+		{Options{Padding: PaddingPowerOfTwo}, 5, 8},
+		{Options{Padding: PaddingPowerOfTwo}, 8, 8},
+		{Options{Padding: PaddingBucket}, 0, DefaultPaddingBlocks},
+		{Options{Padding: PaddingBucket}, 9, 16},
+		{Options{Padding: PaddingBucket, PaddingBlocks: 5}, 10, 10},
+		{Options{Padding: PaddingBucket, PaddingBlocks: 5}, 11, 15},
+	} {
+		padded, err := test.options.paddedCount(test.count)
+		if err != nil || padded != test.padded {
+			t.Errorf("%q padding of %d blocks = %d, %v, expected %d", test.options.Padding, test.count, padded, err, test.padded)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		padded, err := Options{Padding: PaddingRandom, PaddingBlocks: 3}.paddedCount(4)
+		if err != nil || padded < 4 || padded > 7 {
+			t.Fatalf("Random padding of 4 blocks = %d, %v, expected 4 to 7", padded, err)
+		}
+	}
+
+	if _, err := (Options{Padding: "unknown"}).paddedCount(1); err == nil {
+		t.Error("Unknown padding succeeded")
+	}
+}
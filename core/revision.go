@@ -0,0 +1,164 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// Updating a file replaces its key file with the next version, which has new
+// salts and so new blocks, and records the versions before it in its layout,
+// newest first. The key file of a revision is replaced, but its ephemeral key
+// and salts are enough to read its blocks with the private key of the file.
+
+// Revision is a previous version of a file whose blocks are kept
+type Revision struct {
+	Version  uint32 `json:"Version"`
+	Format   int    `json:"Format"`
+	EphemKey []byte `json:"EphemKey"`
+	MetaSalt []byte `json:"MetaSalt"`
+	FileSalt []byte `json:"FileSalt"`
+	Layout   Layout `json:"Layout"`
+}
+
+// ToRevision returns the revision of a key file, without the revisions it
+// records
+func (f *KeyFile) ToRevision() (Revision, error) {
+	version, err := f.GetVersion()
+	if err != nil {
+		return Revision{}, err
+	}
+
+	layout, err := f.GetLayout()
+	if err != nil {
+		return Revision{}, err
+	}
+	layout.Revisions = nil
+
+	return Revision{
+		Version:  version,
+		Format:   f.Format,
+		EphemKey: f.EphemKey,
+		MetaSalt: f.MetaSalt,
+		FileSalt: f.FileSalt,
+		Layout:   layout,
+	}, nil
+}
+
+// Revision returns the key file of a revision of the same file, which is not
+// signed, for reading its blocks
+func (f *KeyFile) Revision(revision Revision) (KeyFile, error) {
+	privBytes, err := f.file.SerializedPrivKey()
+	if err != nil {
+		return KeyFile{}, err
+	}
+	privateKey := secp256k1.PrivKeyFromBytes(privBytes)
+
+	ephemKey, err := secp256k1.ParsePubKey(revision.EphemKey)
+	if err != nil {
+		return KeyFile{}, err
+	}
+
+	keyFile := KeyFile{
+		key:      secp256k1.GenerateSharedSecret(privateKey, ephemKey),
+		file:     f.file,
+		Version:  []byte(strconv.Itoa(int(revision.Version))),
+		MetaSalt: revision.MetaSalt,
+		FileSalt: revision.FileSalt,
+		EphemKey: revision.EphemKey,
+		Format:   revision.Format,
+	}
+
+	if revision.Format >= FormatBound {
+		err = keyFile.SetLayout(revision.Layout)
+		if err != nil {
+			return KeyFile{}, err
+		}
+	}
+
+	return keyFile, nil
+}
+
+// KeepRevisions records previous and the revisions it records as the
+// revisions of the key file, up to keep of them and skipping the key file
+// itself, and returns the revisions no longer kept. The key file must be
+// signed again.
+func (f *KeyFile) KeepRevisions(previous KeyFile, keep int) ([]Revision, error) {
+	if f.Format < FormatBound {
+		return nil, fmt.Errorf("Key files before format %d have no layout", FormatBound)
+	}
+
+	current, err := previous.ToRevision()
+	if err != nil {
+		return nil, err
+	}
+
+	layout, err := previous.GetLayout()
+	if err != nil {
+		return nil, err
+	}
+
+	kept := []Revision{}
+	dropped := []Revision{}
+	for _, revision := range append([]Revision{current}, layout.Revisions...) {
+		switch {
+		case bytes.Equal(revision.MetaSalt, f.MetaSalt) && bytes.Equal(revision.FileSalt, f.FileSalt):
+			continue
+		case len(kept) < keep:
+			kept = append(kept, revision)
+		default:
+			dropped = append(dropped, revision)
+		}
+	}
+
+	layout, err = f.GetLayout()
+	if err != nil {
+		return nil, err
+	}
+	layout.Revisions = kept
+
+	return dropped, f.SetLayout(layout)
+}
+
+// Rollback returns the next version of a key file with the blocks of one of
+// its revisions, keeping the key file as a revision, and the revisions no
+// longer kept
+func Rollback(keyFile KeyFile, version uint32, keep int) (KeyFile, []Revision, error) {
+	layout, err := keyFile.GetLayout()
+	if err != nil {
+		return KeyFile{}, nil, err
+	}
+
+	current, err := keyFile.GetVersion()
+	if err != nil {
+		return KeyFile{}, nil, err
+	}
+
+	for _, revision := range layout.Revisions {
+		if revision.Version != version {
+			continue
+		}
+
+		target, err := keyFile.Revision(revision)
+		if err != nil {
+			return KeyFile{}, nil, err
+		}
+		target.Version = []byte(strconv.Itoa(int(current + 1)))
+
+		dropped, err := target.KeepRevisions(keyFile, keep)
+		if err != nil {
+			return KeyFile{}, nil, err
+		}
+
+		err = target.Sign()
+		if err != nil {
+			return KeyFile{}, nil, err
+		}
+
+		return target, dropped, nil
+	}
+
+	return KeyFile{}, nil, fmt.Errorf("No revision %d", version)
+}
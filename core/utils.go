@@ -4,6 +4,7 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"fmt"
 	"io"
 
 	"github.com/decred/dcrd/dcrec/secp256k1/v4"
@@ -12,7 +13,7 @@ import (
 
 // RandomBytes returns an array of random bytes for a given length
 func RandomBytes(n int) ([]byte, error) {
-	b := make([]byte, 12)
+	b := make([]byte, n)
 	if _, err := io.ReadFull(rand.Reader, b); err != nil {
 		return nil, err
 	}
@@ -41,6 +42,11 @@ func GetPrivateKeyFromHDKey(key *hdkeychain.ExtendedKey) (*secp256k1.PrivateKey,
 
 // Encrypt returns encrypted cipher text
 func Encrypt(key []byte, data []byte) ([]byte, error) {
+	return EncryptWithAD(key, data, nil)
+}
+
+// EncryptWithAD returns encrypted cipher text authenticating additional data
+func EncryptWithAD(key []byte, data []byte, ad []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
@@ -56,13 +62,22 @@ func Encrypt(key []byte, data []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	encrypted := aesgcm.Seal(nil, nonce, data, nil)
+	encrypted := aesgcm.Seal(nil, nonce, data, ad)
 
 	return append(nonce, encrypted...), nil
 }
 
 // Decrypt retuns decrypted plain text
 func Decrypt(key []byte, data []byte) ([]byte, error) {
+	return DecryptWithAD(key, data, nil)
+}
+
+// DecryptWithAD retuns decrypted plain text, failing unless the additional
+// data matches the data it was encrypted with
+func DecryptWithAD(key []byte, data []byte, ad []byte) ([]byte, error) {
+	if len(data) < 12 {
+		return nil, fmt.Errorf("Cipher text too short")
+	}
 	nonce := data[:12]
 
 	block, err := aes.NewCipher(key)
@@ -75,7 +90,7 @@ func Decrypt(key []byte, data []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	decrypted, err := aesgcm.Open(nil, nonce, data[12:], nil)
+	decrypted, err := aesgcm.Open(nil, nonce, data[12:], ad)
 	if err != nil {
 		return nil, err
 	}